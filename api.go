@@ -0,0 +1,1452 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Default number of connections returned by /api/top.
+	DefaultTopConnections = 100
+
+	// Default maximum number of hops for /api/path.
+	DefaultPathHops = 4
+
+	// Default delimiter between cell type and body id for /api/aggregate.
+	DefaultTypeDelimiter = " "
+
+	// Maximum number of cell names suggested by /api/names.
+	MaxNameSuggestions = 50
+
+	// Default number of partners in each direction for /api/neighbors.
+	DefaultNeighbors = 20
+
+	// Number of matches returned by /api/similar unless "n" is given.
+	DefaultSimilarCells = 10
+
+	// Default number of connections returned by /api/around.
+	DefaultAroundConnections = 50
+
+	// Maximum number of queries in one /api/batch request.
+	MaxBatchQueries = 1000
+
+	// Default depth and cell limit for /api/neighborhood.
+	DefaultNeighborhoodDepth = 2
+	DefaultNeighborhoodCells = 500
+
+	// Default and maximum number of steps in a /api/walk.
+	DefaultWalkSteps = 10
+	MaxWalkSteps     = 10000
+)
+
+// connectionJSON is the JSON form of a Connection with optional
+// per-request annotations.
+type connectionJSON struct {
+	Pre          string   `json:"pre"`
+	Post         string   `json:"post"`
+	Strength     int      `json:"strength"`
+	Rank         int      `json:"rank,omitempty"`
+	InputPercent *float64 `json:"input_percent,omitempty"`
+	Percentile   *float64 `json:"percentile,omitempty"`
+}
+
+// MarshalJSON encodes a connection as {"pre":..., "post":..., "strength":...}.
+func (c Connection) MarshalJSON() ([]byte, error) {
+	return json.Marshal(connectionJSON{Pre: c.pre, Post: c.post, Strength: c.strength})
+}
+
+// writeJSON sends the given value as a JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("writing JSON response", "err", err)
+	}
+}
+
+// APIError is the JSON body of an API error response.
+type APIError struct {
+	Error string `json:"error"`
+}
+
+// apiError sends an error response with the given status and a JSON
+// {"error":...} body, the API's counterpart to http.Error.
+func apiError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(APIError{message}); err != nil {
+		slog.Error("writing JSON error response", "err", err)
+	}
+}
+
+// recoverAPI wraps an API handler so a panic is logged and answered with a
+// JSON 500 error rather than dropping the connection.
+func recoverAPI(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				slog.Error("panic serving request", "id", requestID(r), "path", r.URL.Path, "panic", err)
+				apiError(w, "Internal server error.", http.StatusInternalServerError)
+			}
+		}()
+		handler(w, r)
+	}
+}
+
+// intParam returns the integer value of the named request parameter or
+// the given default if the parameter is absent.
+func intParam(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("parameter '%s' must be an integer, got %q", name, value)
+	}
+	return n, nil
+}
+
+// floatParam returns the numeric value of the named request parameter or
+// the given default if the parameter is absent.
+func floatParam(r *http.Request, name string, defaultValue float64) (float64, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("parameter '%s' must be a number, got %q", name, value)
+	}
+	return f, nil
+}
+
+// boolParam returns the boolean value of the named request parameter or
+// the given default if the parameter is absent.
+func boolParam(r *http.Request, name string, defaultValue bool) (bool, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("parameter '%s' must be a boolean, got %q", name, value)
+	}
+	return b, nil
+}
+
+// directionParam returns the Direction named by the request parameter,
+// "in", "out" or "both", or the given default if the parameter is absent.
+func directionParam(r *http.Request, name string, defaultValue Direction) (Direction, error) {
+	switch value := r.FormValue(name); value {
+	case "":
+		return defaultValue, nil
+	case "in":
+		return Incoming, nil
+	case "out":
+		return Outgoing, nil
+	case "both":
+		return BothDirections, nil
+	default:
+		return 0, fmt.Errorf("parameter '%s' must be 'in', 'out' or 'both', got %q", name, value)
+	}
+}
+
+// directionName returns the parameter value naming a Direction.
+func directionName(dir Direction) string {
+	switch dir {
+	case Incoming:
+		return "in"
+	case Outgoing:
+		return "out"
+	}
+	return "both"
+}
+
+// similarityMetricParam returns the SimilarityMetric named by the "metric"
+// parameter, "jaccard" (default) or "cosine".
+func similarityMetricParam(r *http.Request) (SimilarityMetric, error) {
+	switch metric := r.FormValue("metric"); metric {
+	case "", "jaccard":
+		return JaccardSimilarity, nil
+	case "cosine":
+		return CosineSimilarity, nil
+	default:
+		return 0, fmt.Errorf("parameter 'metric' must be 'jaccard' or 'cosine', got %q", metric)
+	}
+}
+
+// searchOptions returns the SearchOptions requested by these parameters:
+//
+//	ci            match names regardless of case (default: -ignorecase flag)
+//	exclude_self  drop self-connections
+//	min_strength  minimum connection strength
+//	max_strength  maximum connection strength (default: unbounded)
+//	normalize     "input" to show percentage of postsynaptic input
+//	offset        number of sorted connections to skip
+//	limit         maximum number of connections to return
+//	regex         treat pre and post as regular expressions
+//	fuzzy         retry exact patterns matching no name as prefixes
+//	sort          "strength_desc" (default), "strength_asc", "pre" or "post"
+func (s *Server) searchOptions(r *http.Request) (opts SearchOptions, err error) {
+	if opts.IgnoreCase, err = boolParam(r, "ci", s.config.IgnoreCase); err != nil {
+		return
+	}
+	if opts.ExcludeSelf, err = boolParam(r, "exclude_self", false); err != nil {
+		return
+	}
+	if opts.MinStrength, err = intParam(r, "min_strength", 0); err != nil {
+		return
+	}
+	if opts.MaxStrength, err = intParam(r, "max_strength", 0); err != nil {
+		return
+	}
+	if opts.MaxStrength != 0 && opts.MaxStrength < opts.MinStrength {
+		return opts, fmt.Errorf("parameter 'max_strength' must not be less than 'min_strength'")
+	}
+	switch normalize := r.FormValue("normalize"); normalize {
+	case "":
+	case "input":
+		opts.Normalize = true
+	default:
+		return opts, fmt.Errorf("illegal normalize parameter %q, use 'input'", normalize)
+	}
+	if opts.Offset, err = intParam(r, "offset", 0); err != nil {
+		return
+	}
+	if opts.Limit, err = intParam(r, "limit", 0); err != nil {
+		return
+	}
+	if opts.Offset < 0 || opts.Limit < 0 {
+		return opts, fmt.Errorf("parameters 'offset' and 'limit' must not be negative")
+	}
+	if opts.Regex, err = boolParam(r, "regex", false); err != nil {
+		return
+	}
+	if opts.Fuzzy, err = boolParam(r, "fuzzy", false); err != nil {
+		return
+	}
+	switch order := r.FormValue("sort"); order {
+	case "", "strength", SortStrengthDesc:
+		opts.Sort = SortStrengthDesc
+	case "name", SortPre:
+		opts.Sort = SortPre
+	case SortStrengthAsc, SortPost:
+		opts.Sort = order
+	default:
+		return opts, fmt.Errorf("illegal sort parameter %q, use 'strength_desc', 'strength_asc', 'pre' or 'post'", order)
+	}
+	return
+}
+
+// Handler for JSON search requests.  Accepts the same "pre" and "post"
+// values as the HTML search via either POST form or GET query string.
+// An optional "sort" parameter of "strength_desc" (default, or "strength"),
+// "strength_asc", "pre" (or "name") or "post" sets the order of returned
+// connections, as read by searchOptions.  The total number of connections before
+// any offset or limit is sent in the X-Total-Count header, and the sum of
+// their strengths in the X-Total-Synapses header.
+func (s *Server) apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if status, err := s.parseForm(w, r); err != nil {
+		apiError(w, err.Error(), status)
+		return
+	}
+	opts, err := s.searchOptions(r)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	withMatches, err := boolParam(r, "matches", false)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d, err := s.requestData(r)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	preMatches, postMatches, connections, unfiltered, err := s.searchWithMatches(r, d, opts)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	connections.SortBy(opts.Sort)
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(connections)))
+	w.Header().Set("X-Total-Synapses", strconv.Itoa(connections.TotalStrength()))
+	w.Header().Set("X-Unfiltered-Count", strconv.Itoa(unfiltered))
+	prefixTerms := append(d.PrefixTerms(r.FormValue("pre"), opts), d.PrefixTerms(r.FormValue("post"), opts)...)
+	if len(prefixTerms) > 0 {
+		w.Header().Set("X-Prefix-Terms", strings.Join(prefixTerms, ","))
+	}
+	ranks := connections.StrengthRanks()
+	connections = connections.Page(opts.Offset, opts.Limit)
+	annotated := make([]connectionJSON, len(connections))
+	for i, connection := range connections {
+		percentile := math.Round(d.Percentile(connection.strength)*10) / 10
+		annotated[i] = connectionJSON{connection.pre, connection.post, connection.strength,
+			ranks.Rank(connection.strength), nil, &percentile}
+		if opts.Normalize {
+			percent := math.Round(d.InputPercent(connection)*100) / 100
+			annotated[i].InputPercent = &percent
+		}
+	}
+	var results interface{} = annotated
+	if withMatches {
+		results = SearchResult{sortedNames(preMatches), sortedNames(postMatches), prefixTerms, unfiltered, results}
+	}
+	writeJSON(w, results)
+}
+
+// SearchResult is the response to /api/search with matches=1, giving the
+// names each side's patterns matched along with the connections.
+type SearchResult struct {
+	PreMatches  []string    `json:"pre_matches"`
+	PostMatches []string    `json:"post_matches"`
+	PrefixTerms []string    `json:"prefix_terms,omitempty"`
+	Unfiltered  int         `json:"unfiltered_count"`
+	Connections interface{} `json:"connections"`
+}
+
+// BatchQuery is one pair of search patterns in a batch request.
+type BatchQuery struct {
+	Pre  string `json:"pre"`
+	Post string `json:"post"`
+}
+
+// BatchResult is the result set for one BatchQuery.  Error is set instead
+// of Connections if the query's patterns could not be resolved.  Total and
+// Unfiltered count the query's connections before any offset or limit,
+// with and without the strength window, as /api/search reports them.
+// Truncated is set if Connections was cut short by the -max-results cap on
+// the whole batch.
+type BatchResult struct {
+	Pre         string         `json:"pre"`
+	Post        string         `json:"post"`
+	Total       int            `json:"total_count"`
+	Unfiltered  int            `json:"unfiltered_count"`
+	Connections ConnectionList `json:"connections"`
+	Truncated   bool           `json:"truncated,omitempty"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// errTooManyQueries is returned by decodeBatch for a batch over
+// MaxBatchQueries.
+var errTooManyQueries = fmt.Errorf("Too many queries in batch (max %d).", MaxBatchQueries)
+
+// decodeBatch reads a JSON array of queries one element at a time, so a
+// batch over MaxBatchQueries is refused without reading the rest of it.
+func decodeBatch(body io.Reader) ([]BatchQuery, error) {
+	decoder := json.NewDecoder(body)
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('[') {
+		return nil, fmt.Errorf("expected an array, got %v", token)
+	}
+	queries := make([]BatchQuery, 0)
+	for decoder.More() {
+		if len(queries) == MaxBatchQueries {
+			return nil, errTooManyQueries
+		}
+		var query BatchQuery
+		if err := decoder.Decode(&query); err != nil {
+			return nil, err
+		}
+		queries = append(queries, query)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return queries, nil
+}
+
+// Handler for a POSTed JSON array of {"pre":...,"post":...} queries,
+// returning an array of result sets in the same order, each sorted by
+// strength.  Query parameters set the search options for all queries.
+// Searches share the dataset's search cache, and at most -max-results
+// connections are returned across the whole batch.
+func (s *Server) apiBatchHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode the body before parsing options so it isn't read as a form.
+	s.limitBody(w, r)
+	queries, err := decodeBatch(r.Body)
+	if msg, found := bodyTooLarge(err); found {
+		apiError(w, msg, http.StatusRequestEntityTooLarge)
+		return
+	} else if err == errTooManyQueries {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		apiError(w, "Illegal batch request.  Requires a JSON array of queries: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := s.searchOptions(r)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d, err := s.requestData(r)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results := make([]BatchResult, len(queries))
+	total, returned, truncated := 0, 0, 0
+	for i, query := range queries {
+		results[i] = BatchResult{Pre: query.Pre, Post: query.Post}
+		result, _, err := s.cachedSearch(d, query.Pre, query.Post, opts)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		connections := result.connections.InRange(opts)
+		connections.SortBy(opts.Sort)
+		results[i].Total, results[i].Unfiltered = len(connections), len(result.connections)
+		page := connections.Page(opts.Offset, opts.Limit)
+		if max := s.config.MaxResults; max > 0 && returned+len(page) > max {
+			page = page[:max-returned]
+			results[i].Truncated = true
+			truncated++
+		}
+		if page == nil {
+			// A cached empty result is nil, which would encode as null.
+			page = ConnectionList{}
+		}
+		results[i].Connections = page
+		total += len(connections)
+		returned += len(page)
+	}
+	logAttrs(r, "queries", len(queries), "results", total, "returned", returned, "truncated", truncated)
+	writeJSON(w, results)
+}
+
+// Handler for requests of all presynaptic partners of the given "cell",
+// returned in order of strength.
+func (s *Server) apiIncomingHandler(w http.ResponseWriter, r *http.Request) {
+	cell := r.FormValue("cell")
+	if cell == "" {
+		apiError(w, "Illegal incoming request.  Requires 'cell' parameter.", http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	if !d.CellSet[cell] {
+		apiError(w, "Unknown cell: "+cell, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, d.Connectivity.IncomingConnections(cell))
+}
+
+// DirectedConnection is a connection of a cell tagged with its direction
+// from that cell: "out", "in", or "self" for a self-connection.
+type DirectedConnection struct {
+	Pre       string `json:"pre"`
+	Post      string `json:"post"`
+	Strength  int    `json:"strength"`
+	Direction string `json:"direction"`
+}
+
+// Around returns every connection from or onto cell in one list, strongest
+// first and then by pre and post name.  A self-connection is listed once.
+func (d *Dataset) Around(cell string) []DirectedConnection {
+	nc := d.Connectivity
+	connections := make([]DirectedConnection, 0)
+	for _, connection := range nc.OutgoingConnections(cell) {
+		direction := "out"
+		if connection.post == cell {
+			direction = "self"
+		}
+		connections = append(connections, DirectedConnection{connection.pre, connection.post, connection.strength, direction})
+	}
+	for _, connection := range nc.IncomingConnections(cell) {
+		if connection.pre != cell {
+			connections = append(connections, DirectedConnection{connection.pre, connection.post, connection.strength, "in"})
+		}
+	}
+	sort.Slice(connections, func(i, j int) bool {
+		a, b := connections[i], connections[j]
+		if a.Strength != b.Strength {
+			return a.Strength > b.Strength
+		}
+		if a.Pre != b.Pre {
+			return a.Pre < b.Pre
+		}
+		return a.Post < b.Post
+	})
+	return connections
+}
+
+// Handler for the "n" strongest connections of "cell" in either direction,
+// ranked together and tagged with their direction.  An "n" of 0 returns
+// them all.
+func (s *Server) apiAroundHandler(w http.ResponseWriter, r *http.Request) {
+	cell := r.FormValue("cell")
+	if cell == "" {
+		apiError(w, "Illegal around request.  Requires 'cell' parameter.", http.StatusBadRequest)
+		return
+	}
+	n, err := intParam(r, "n", DefaultAroundConnections)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n < 0 {
+		apiError(w, "Parameter 'n' must not be negative.", http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	if !d.CellSet[cell] {
+		apiError(w, "Unknown cell: "+cell, http.StatusNotFound)
+		return
+	}
+	connections := d.Around(cell)
+	if n > 0 && n < len(connections) {
+		connections = connections[:n]
+	}
+	writeJSON(w, connections)
+}
+
+// Partner is a cell connected to another and the strength of that
+// connection.
+type Partner struct {
+	Cell     string `json:"cell"`
+	Strength int    `json:"strength"`
+}
+
+// Neighbors holds a cell's strongest partners in each direction.
+type Neighbors struct {
+	Cell     string    `json:"cell"`
+	Outgoing []Partner `json:"outgoing"`
+	Incoming []Partner `json:"incoming"`
+}
+
+// strongestPartners returns the partners at the given end of the
+// strength-sorted connections, keeping at most n, or all if n is 0, of at
+// least minStrength.
+func strongestPartners(connections ConnectionList, outgoing bool, n, minStrength int) []Partner {
+	if n == 0 || n > len(connections) {
+		n = len(connections)
+	}
+	partners := make([]Partner, 0, n)
+	for _, connection := range connections {
+		if len(partners) == n || connection.strength < minStrength {
+			break
+		}
+		partner := Partner{connection.pre, connection.strength}
+		if outgoing {
+			partner.Cell = connection.post
+		}
+		partners = append(partners, partner)
+	}
+	return partners
+}
+
+// Handler for the "n" strongest postsynaptic and presynaptic partners of
+// the given "cell", or all of them for an "n" of 0.  An optional
+// "min_strength" excludes weaker partners.
+func (s *Server) apiNeighborsHandler(w http.ResponseWriter, r *http.Request) {
+	cell := r.FormValue("cell")
+	if cell == "" {
+		apiError(w, "Illegal neighbors request.  Requires 'cell' parameter.", http.StatusBadRequest)
+		return
+	}
+	n, err := intParam(r, "n", DefaultNeighbors)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minStrength, err := intParam(r, "min_strength", 0)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n < 0 {
+		apiError(w, "Parameter 'n' must not be negative.", http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	if !d.CellSet[cell] {
+		apiError(w, "Unknown cell: "+cell, http.StatusNotFound)
+		return
+	}
+	nc := d.Connectivity
+	writeJSON(w, Neighbors{
+		Cell:     cell,
+		Outgoing: strongestPartners(nc.OutgoingConnections(cell), true, n, minStrength),
+		Incoming: strongestPartners(nc.IncomingConnections(cell), false, n, minStrength),
+	})
+}
+
+// OutputShare is a postsynaptic partner with the fraction of the
+// presynaptic cell's total output that goes to it.
+type OutputShare struct {
+	Cell     string  `json:"cell"`
+	Strength int     `json:"strength"`
+	Fraction float64 `json:"fraction"`
+}
+
+// OutputProfile returns every postsynaptic partner of cell with its share
+// of the cell's output, strongest first.  It is empty for a cell without
+// output.
+func (d *Dataset) OutputProfile(cell string) []OutputShare {
+	connections := d.Connectivity.OutgoingConnections(cell)
+	total := connections.TotalStrength()
+	profile := make([]OutputShare, 0, len(connections))
+	if total == 0 {
+		return profile
+	}
+	for _, connection := range connections {
+		profile = append(profile, OutputShare{
+			Cell:     connection.post,
+			Strength: connection.strength,
+			Fraction: float64(connection.strength) / float64(total),
+		})
+	}
+	return profile
+}
+
+// Handler for the postsynaptic partners of the given "cell" with the
+// fraction of its output each receives.
+func (s *Server) apiOutputProfileHandler(w http.ResponseWriter, r *http.Request) {
+	cell := r.FormValue("cell")
+	if cell == "" {
+		apiError(w, "Illegal output profile request.  Requires 'cell' parameter.", http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	if !d.CellSet[cell] {
+		apiError(w, "Unknown cell: "+cell, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, d.OutputProfile(cell))
+}
+
+// Handler for the "n" strongest connections across the whole connectome,
+// or all of them for an "n" of 0.  An optional "min_strength" excludes
+// weaker connections.
+func (s *Server) apiTopHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := intParam(r, "n", DefaultTopConnections)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minStrength, err := intParam(r, "min_strength", 0)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n < 0 {
+		apiError(w, "Parameter 'n' must not be negative.", http.StatusBadRequest)
+		return
+	}
+	all := s.currentData().Connectivity.AllConnections()
+	connections := make(ConnectionList, 0, len(all))
+	for _, connection := range all {
+		if connection.strength >= minStrength {
+			connections = append(connections, connection)
+		}
+	}
+	connections.SortByStrength()
+	if n > 0 && n < len(connections) {
+		connections = connections[:n]
+	}
+	writeJSON(w, connections)
+}
+
+// Edge is the JSON response for /api/edge.
+type Edge struct {
+	Pre      string `json:"pre"`
+	Post     string `json:"post"`
+	Exists   bool   `json:"exists"`
+	Strength int    `json:"strength"`
+}
+
+// Handler for whether the exact "pre" cell connects to the exact "post"
+// cell, and with what strength.  Names are not expanded as patterns.
+func (s *Server) apiEdgeHandler(w http.ResponseWriter, r *http.Request) {
+	pre, post := r.FormValue("pre"), r.FormValue("post")
+	if pre == "" || post == "" {
+		apiError(w, "Illegal edge request.  Requires 'pre' and 'post' parameters.", http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	for _, cell := range []string{pre, post} {
+		if !d.CellSet[cell] {
+			apiError(w, "Unknown cell: "+cell, http.StatusNotFound)
+			return
+		}
+	}
+	strength, exists := d.Connectivity.ConnectionStrength(pre, post)
+	writeJSON(w, Edge{pre, post, exists, strength})
+}
+
+// Degree summarizes the synapse totals and partner counts of one cell.
+type Degree struct {
+	Cell        string `json:"cell"`
+	OutSynapses int    `json:"out_synapses"`
+	InSynapses  int    `json:"in_synapses"`
+	OutPartners int    `json:"out_partners"`
+	InPartners  int    `json:"in_partners"`
+}
+
+// Handler for the total input and output of the given "cell".
+func (s *Server) apiDegreeHandler(w http.ResponseWriter, r *http.Request) {
+	cell := r.FormValue("cell")
+	if cell == "" {
+		apiError(w, "Illegal degree request.  Requires 'cell' parameter.", http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	if !d.CellSet[cell] {
+		apiError(w, "Unknown cell: "+cell, http.StatusNotFound)
+		return
+	}
+	nc := d.Connectivity
+	writeJSON(w, Degree{
+		Cell:        cell,
+		OutSynapses: nc.OutDegree(cell),
+		InSynapses:  d.InSynapses[cell],
+		OutPartners: nc.OutPartners(cell),
+		InPartners:  nc.InPartners(cell),
+	})
+}
+
+// CellTotal is a cell and its total synapses in one direction.
+type CellTotal struct {
+	Cell     string `json:"cell"`
+	Synapses int    `json:"synapses"`
+}
+
+// Stats summarizes a whole dataset.  Strength statistics cover only
+// nonzero connections.
+type Stats struct {
+	Cells        int       `json:"cells"`
+	Connections  int       `json:"connections"`
+	Synapses     int       `json:"synapses"`
+	MinStrength  int       `json:"min_strength"`
+	MaxStrength  int       `json:"max_strength"`
+	MeanStrength float64   `json:"mean_strength"`
+	Density      float64   `json:"density"`
+	DensestPre   CellTotal `json:"densest_pre"`
+	DensestPost  CellTotal `json:"densest_post"`
+}
+
+// denser returns true if total should replace best as the densest cell,
+// breaking ties alphabetically.
+func denser(total, best CellTotal) bool {
+	return total.Synapses > best.Synapses ||
+		(total.Synapses == best.Synapses && total.Cell < best.Cell)
+}
+
+// computeStats returns the dataset's summary statistics.
+func (d *Dataset) computeStats() (stats Stats) {
+	stats.Cells = len(d.Cells)
+	outSynapses := make(map[string]int)
+	d.Connectivity.EachConnection(func(pre, post string, strength int) {
+		if stats.Connections == 0 || strength < stats.MinStrength {
+			stats.MinStrength = strength
+		}
+		if strength > stats.MaxStrength {
+			stats.MaxStrength = strength
+		}
+		stats.Connections++
+		stats.Synapses += strength
+		outSynapses[pre] += strength
+	})
+	if stats.Connections > 0 {
+		stats.MeanStrength = float64(stats.Synapses) / float64(stats.Connections)
+		// Any cell, itself included, could connect to any cell.
+		stats.Density = float64(stats.Connections) / (float64(stats.Cells) * float64(stats.Cells))
+	}
+	for cell, synapses := range outSynapses {
+		if total := (CellTotal{cell, synapses}); denser(total, stats.DensestPre) {
+			stats.DensestPre = total
+		}
+	}
+	for cell, synapses := range d.InSynapses {
+		if total := (CellTotal{cell, synapses}); denser(total, stats.DensestPost) {
+			stats.DensestPost = total
+		}
+	}
+	return
+}
+
+// Handler for the summary statistics of the loaded dataset.
+func (s *Server) apiStatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.currentData().Stats)
+}
+
+// Isolation lists the cells with an empty row (no outgoing connections),
+// an empty column (no incoming connections), or both.  Each list is in
+// alphabetical order and fully isolated cells appear in all three.
+type Isolation struct {
+	NoOutgoing []string `json:"no_outgoing"`
+	NoIncoming []string `json:"no_incoming"`
+	Isolated   []string `json:"isolated"`
+}
+
+// Isolated returns the cells lacking outgoing or incoming connections.
+func (d *Dataset) Isolated() Isolation {
+	isolation := Isolation{[]string{}, []string{}, []string{}}
+	for _, cell := range d.Cells.Sorted() {
+		noOutgoing := !d.Connectivity.HasOutgoing(cell)
+		noIncoming := d.InSynapses[cell] == 0
+		if noOutgoing {
+			isolation.NoOutgoing = append(isolation.NoOutgoing, cell)
+		}
+		if noIncoming {
+			isolation.NoIncoming = append(isolation.NoIncoming, cell)
+		}
+		if noOutgoing && noIncoming {
+			isolation.Isolated = append(isolation.Isolated, cell)
+		}
+	}
+	return isolation
+}
+
+// Handler for the cells without outgoing or incoming connections.
+func (s *Server) apiIsolatedHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.currentData().Isolated())
+}
+
+// StrengthChange compares the strength of a connection in two datasets.
+// A connection missing from one dataset has strength 0 there.
+type StrengthChange struct {
+	Pre       string `json:"pre"`
+	Post      string `json:"post"`
+	Strength1 int    `json:"strength1"`
+	Strength2 int    `json:"strength2"`
+	Delta     int    `json:"delta"`
+}
+
+// absDelta returns the size of the change regardless of direction.
+func (change StrengthChange) absDelta() int {
+	if change.Delta < 0 {
+		return -change.Delta
+	}
+	return change.Delta
+}
+
+// Diff returns the connections between cells matching the pre and post
+// patterns in either dataset, with their strengths in d and other, ordered
+// by decreasing size of change and then by name.  Connections whose
+// strength is unchanged are omitted.  The options' strength window is
+// ignored so a weakened connection isn't mistaken for a removed one.
+func (d *Dataset) Diff(other *Dataset, preNames, postNames string, opts SearchOptions) ([]StrengthChange, error) {
+	opts.MinStrength, opts.MaxStrength = 0, 0
+	changes := make(map[[2]string]*StrengthChange)
+	for i, dataset := range []*Dataset{d, other} {
+		connections, err := dataset.SearchConnections(preNames, postNames, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, connection := range connections {
+			key := [2]string{connection.pre, connection.post}
+			change, found := changes[key]
+			if !found {
+				change = &StrengthChange{Pre: connection.pre, Post: connection.post}
+				changes[key] = change
+			}
+			if i == 0 {
+				change.Strength1 = connection.strength
+			} else {
+				change.Strength2 = connection.strength
+			}
+		}
+	}
+	diff := make([]StrengthChange, 0, len(changes))
+	for _, change := range changes {
+		change.Delta = change.Strength2 - change.Strength1
+		if change.Delta != 0 {
+			diff = append(diff, *change)
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool {
+		if a, b := diff[i].absDelta(), diff[j].absDelta(); a != b {
+			return a > b
+		}
+		if diff[i].Pre != diff[j].Pre {
+			return diff[i].Pre < diff[j].Pre
+		}
+		return diff[i].Post < diff[j].Post
+	})
+	return diff, nil
+}
+
+// Handler for the changes in strength between the "from" and "to" cell
+// patterns from the served connectome to the -connect2 connectome.  Honors
+// the same matching and paging parameters as searches.
+func (s *Server) apiDiffHandler(w http.ResponseWriter, r *http.Request) {
+	from, to := r.FormValue("from"), r.FormValue("to")
+	if from == "" || to == "" {
+		apiError(w, "Illegal diff request.  Requires 'from' and 'to' parameters.", http.StatusBadRequest)
+		return
+	}
+	opts, err := s.searchOptions(r)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d, d2 := s.comparisonData()
+	if d2 == nil {
+		apiError(w, "No second connectome to compare.  Run with -connect2.", http.StatusNotFound)
+		return
+	}
+	diff, err := d.Diff(d2, from, to, opts)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(diff)))
+	start := min(opts.Offset, len(diff))
+	end := len(diff)
+	if opts.Limit > 0 {
+		end = min(start+opts.Limit, end)
+	}
+	writeJSON(w, diff[start:end])
+}
+
+// DegreeHistogram maps each degree value to the number of cells with
+// that degree, in each direction.
+type DegreeHistogram struct {
+	Out map[int]int `json:"out"`
+	In  map[int]int `json:"in"`
+}
+
+// DegreeHistogram returns the distribution of cell degrees, measured as
+// the number of distinct partners or, if bySynapses is set, as the total
+// synapses.  Cells without any connections count toward degree 0.
+func (d *Dataset) DegreeHistogram(bySynapses bool) DegreeHistogram {
+	outDegrees := make(map[string]int)
+	inDegrees := make(map[string]int)
+	d.Connectivity.EachConnection(func(pre, post string, strength int) {
+		if bySynapses {
+			outDegrees[pre] += strength
+			inDegrees[post] += strength
+		} else {
+			outDegrees[pre]++
+			inDegrees[post]++
+		}
+	})
+	histogram := DegreeHistogram{make(map[int]int), make(map[int]int)}
+	for _, cell := range d.Cells {
+		histogram.Out[outDegrees[cell]]++
+		histogram.In[inDegrees[cell]]++
+	}
+	return histogram
+}
+
+// Handler for the histograms of out- and in-degree over all cells.  The
+// "by" parameter is "partners" (default) to count distinct partners, or
+// "synapses" to sum connection strengths.
+func (s *Server) apiDegreeHistogramHandler(w http.ResponseWriter, r *http.Request) {
+	var bySynapses bool
+	switch r.FormValue("by") {
+	case "", "partners":
+	case "synapses":
+		bySynapses = true
+	default:
+		apiError(w, "Illegal by parameter.  Use 'partners' or 'synapses'.", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, s.currentData().DegreeHistogram(bySynapses))
+}
+
+// CellScore is a cell and its value under some ranking metric.
+type CellScore struct {
+	Cell  string `json:"cell"`
+	Score int    `json:"score"`
+}
+
+// Centrality ranks every cell by its degree in the given direction,
+// highest first and then by name.  The degree is the total synapses or, if
+// byPartners is set, the number of distinct partners; with BothDirections
+// a partner connected both ways is counted once.  As in /api/degree, a
+// self-connection counts toward both directions.
+func (d *Dataset) Centrality(dir Direction, byPartners bool) []CellScore {
+	nc := d.Connectivity
+	scores := make([]CellScore, 0, len(d.Cells))
+	for _, cell := range d.Cells {
+		score := 0
+		if byPartners {
+			score = len(nc.neighbors(cell, dir, 0))
+		} else {
+			if dir&Outgoing != 0 {
+				score += nc.OutDegree(cell)
+			}
+			if dir&Incoming != 0 {
+				score += d.InSynapses[cell]
+			}
+		}
+		scores = append(scores, CellScore{cell, score})
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score > scores[j].Score
+		}
+		return scores[i].Cell < scores[j].Cell
+	})
+	return scores
+}
+
+// Handler for ranking cells by degree.  Parameters:
+//
+//	metric     "weighted" for total synapses (default) or "partners"
+//	direction  "in", "out" or "both" (default)
+//	top        maximum number of cells returned (default: all)
+func (s *Server) apiCentralityHandler(w http.ResponseWriter, r *http.Request) {
+	var byPartners bool
+	switch r.FormValue("metric") {
+	case "", "weighted":
+	case "partners":
+		byPartners = true
+	default:
+		apiError(w, "Illegal metric parameter.  Use 'weighted' or 'partners'.", http.StatusBadRequest)
+		return
+	}
+	dir, err := directionParam(r, "direction", BothDirections)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	top, err := intParam(r, "top", 0)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if top < 0 {
+		apiError(w, "Parameter 'top' must not be negative.", http.StatusBadRequest)
+		return
+	}
+	scores := s.currentData().Centrality(dir, byPartners)
+	if top > 0 && top < len(scores) {
+		scores = scores[:top]
+	}
+	writeJSON(w, scores)
+}
+
+// cellsParam returns the distinct cells named by the comma-separated
+// "cells" parameter, which must list at least two known cells.  On a bad
+// list it writes an error response and returns ok as false.
+func cellsParam(w http.ResponseWriter, r *http.Request, d *Dataset) (cells []string, ok bool) {
+	seen := make(map[string]bool)
+	for _, cell := range strings.Split(r.FormValue("cells"), ",") {
+		cell = strings.TrimSpace(cell)
+		if cell == "" || seen[cell] {
+			continue
+		}
+		if !d.CellSet[cell] {
+			apiError(w, "Unknown cell: "+cell, http.StatusNotFound)
+			return nil, false
+		}
+		seen[cell] = true
+		cells = append(cells, cell)
+	}
+	if len(cells) < 2 {
+		apiError(w, "Illegal request.  Requires at least two comma-separated 'cells'.", http.StatusBadRequest)
+		return nil, false
+	}
+	return cells, true
+}
+
+// Handler for the postsynaptic partners shared by every one of "cells".
+func (s *Server) apiCommonTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	d := s.currentData()
+	cells, ok := cellsParam(w, r, d)
+	if !ok {
+		return
+	}
+	writeJSON(w, d.Connectivity.CommonTargets(cells))
+}
+
+// Handler for the presynaptic partners shared by every one of "cells".
+func (s *Server) apiCommonSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	d := s.currentData()
+	cells, ok := cellsParam(w, r, d)
+	if !ok {
+		return
+	}
+	writeJSON(w, d.Connectivity.CommonSources(cells))
+}
+
+// Neighborhood is the subgraph induced by the cells near a seed cell.
+type Neighborhood struct {
+	Cell  string         `json:"cell"`
+	Nodes []string       `json:"nodes"`
+	Edges ConnectionList `json:"edges"`
+
+	// Number of cells within reach that were left out by the cell limit.
+	Truncated int `json:"truncated"`
+}
+
+// Handler for the cells within "depth" hops of "cell" and the connections
+// among them.  Parameters:
+//
+//	direction     "out" (default), "in" or "both" connections to follow
+//	min_strength  ignore weaker connections, both to expand and as edges
+//	max_nodes     most cells returned, in the order reached (default 500)
+func (s *Server) apiNeighborhoodHandler(w http.ResponseWriter, r *http.Request) {
+	cell := r.FormValue("cell")
+	if cell == "" {
+		apiError(w, "Illegal neighborhood request.  Requires 'cell' parameter.", http.StatusBadRequest)
+		return
+	}
+	depth, err := intParam(r, "depth", DefaultNeighborhoodDepth)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if depth < 0 || depth > MaxPathHops {
+		apiError(w, fmt.Sprintf("Parameter 'depth' must be between 0 and %d.", MaxPathHops),
+			http.StatusBadRequest)
+		return
+	}
+	dir, err := directionParam(r, "direction", Outgoing)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minStrength, err := intParam(r, "min_strength", 0)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxNodes, err := intParam(r, "max_nodes", DefaultNeighborhoodCells)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if maxNodes < 1 {
+		apiError(w, "Parameter 'max_nodes' must be positive.", http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	if !d.CellSet[cell] {
+		apiError(w, "Unknown cell: "+cell, http.StatusNotFound)
+		return
+	}
+	cells, truncated := d.Connectivity.LimitedNeighborhood(cell, depth, dir, minStrength, maxNodes)
+	writeJSON(w, Neighborhood{
+		Cell:      cell,
+		Nodes:     cells,
+		Edges:     d.Connectivity.Subgraph(cells, minStrength),
+		Truncated: truncated,
+	})
+}
+
+// SimilarityResult is the JSON response for /api/similarity.
+type SimilarityResult struct {
+	A         string             `json:"a"`
+	B         string             `json:"b"`
+	Direction string             `json:"direction"`
+	Metric    string             `json:"metric"`
+	Score     float64            `json:"score"`
+	Shared    []SharedConnection `json:"shared"`
+}
+
+// Handler for the similarity of the connections of cells "a" and "b".
+// Parameters:
+//
+//	direction  "out" (default), "in" or "both" connections to compare
+//	metric     "jaccard" (weighted, default) or "cosine"
+func (s *Server) apiSimilarityHandler(w http.ResponseWriter, r *http.Request) {
+	a, b := r.FormValue("a"), r.FormValue("b")
+	if a == "" || b == "" {
+		apiError(w, "Illegal similarity request.  Requires 'a' and 'b' parameters.", http.StatusBadRequest)
+		return
+	}
+	dir, err := directionParam(r, "direction", Outgoing)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metric, err := similarityMetricParam(r)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	for _, cell := range []string{a, b} {
+		if !d.CellSet[cell] {
+			apiError(w, "Unknown cell: "+cell, http.StatusNotFound)
+			return
+		}
+	}
+	result := SimilarityResult{A: a, B: b, Direction: directionName(dir), Metric: "jaccard"}
+	if metric == CosineSimilarity {
+		result.Metric = "cosine"
+	}
+	result.Score, result.Shared = d.Connectivity.Similarity(a, b, dir, metric)
+	result.Score = math.Round(result.Score*1e6) / 1e6
+	writeJSON(w, result)
+}
+
+// Handler for the "n" cells whose connections most resemble those of
+// "cell", with the same "direction" and "metric" parameters as
+// /api/similarity.  Each match includes its score and the number of
+// partners it shares with the cell.
+func (s *Server) apiSimilarHandler(w http.ResponseWriter, r *http.Request) {
+	cell := r.FormValue("cell")
+	if cell == "" {
+		apiError(w, "Illegal similar request.  Requires 'cell' parameter.", http.StatusBadRequest)
+		return
+	}
+	n, err := intParam(r, "n", DefaultSimilarCells)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n < 0 {
+		apiError(w, "Parameter 'n' must not be negative.", http.StatusBadRequest)
+		return
+	}
+	dir, err := directionParam(r, "direction", Outgoing)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metric, err := similarityMetricParam(r)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	if !d.CellSet[cell] {
+		apiError(w, "Unknown cell: "+cell, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, d.Connectivity.MostSimilar(cell, dir, metric, n))
+}
+
+// PathResult is the JSON response for path queries.
+type PathResult struct {
+	Found bool     `json:"found"`
+	Path  []string `json:"path"`
+}
+
+// Handler for the shortest path between the "from" and "to" cells of at
+// most "maxhops" connections.
+func (s *Server) apiPathHandler(w http.ResponseWriter, r *http.Request) {
+	from, to := r.FormValue("from"), r.FormValue("to")
+	if from == "" || to == "" {
+		apiError(w, "Illegal path request.  Requires 'from' and 'to' parameters.", http.StatusBadRequest)
+		return
+	}
+	maxHops, err := intParam(r, "maxhops", DefaultPathHops)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if maxHops < 0 || maxHops > MaxPathHops {
+		apiError(w, fmt.Sprintf("Parameter 'maxhops' must be between 0 and %d.", MaxPathHops),
+			http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	for _, cell := range []string{from, to} {
+		if !d.CellSet[cell] {
+			apiError(w, "Unknown cell: "+cell, http.StatusNotFound)
+			return
+		}
+	}
+	path, found := d.Connectivity.ShortestPath(from, to, maxHops)
+	if path == nil {
+		path = []string{}
+	}
+	writeJSON(w, PathResult{found, path})
+}
+
+// WalkResult is the JSON response for /api/walk.
+type WalkResult struct {
+	Seed    int64    `json:"seed"`
+	Path    []string `json:"path"`
+	DeadEnd bool     `json:"dead_end"`
+}
+
+// Handler for a random walk of up to "steps" connections from the "start"
+// cell, weighted by strength.  A given "seed" makes the walk reproducible;
+// otherwise a random seed is chosen and returned.
+func (s *Server) apiWalkHandler(w http.ResponseWriter, r *http.Request) {
+	start := r.FormValue("start")
+	if start == "" {
+		apiError(w, "Illegal walk request.  Requires 'start' parameter.", http.StatusBadRequest)
+		return
+	}
+	steps, err := intParam(r, "steps", DefaultWalkSteps)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if steps < 0 || steps > MaxWalkSteps {
+		apiError(w, fmt.Sprintf("Parameter 'steps' must be between 0 and %d.", MaxWalkSteps),
+			http.StatusBadRequest)
+		return
+	}
+	// Chosen seeds fit in 53 bits so JavaScript clients can pass them back.
+	seed := rand.Int63n(1 << 53)
+	if r.FormValue("seed") != "" {
+		value, err := intParam(r, "seed", 0)
+		if err != nil {
+			apiError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		seed = int64(value)
+	}
+	d := s.currentData()
+	if !d.CellSet[start] {
+		apiError(w, "Unknown cell: "+start, http.StatusNotFound)
+		return
+	}
+	path, deadEnd := d.Connectivity.RandomWalk(start, steps, rand.New(rand.NewSource(seed)))
+	writeJSON(w, WalkResult{seed, path, deadEnd})
+}
+
+// StrongPathResult is the JSON response for strongest path queries.
+type StrongPathResult struct {
+	Found  bool     `json:"found"`
+	Path   []string `json:"path"`
+	Score  float64  `json:"score"`
+	Metric string   `json:"metric"`
+}
+
+// Handler for the strongest path between the "from" and "to" cells.  The
+// "metric" parameter is "bottleneck" (default), which maximizes the weakest
+// connection along the path, or "inverse", which minimizes the sum of
+// 1/strength over the path's connections.
+func (s *Server) apiStrongPathHandler(w http.ResponseWriter, r *http.Request) {
+	from, to := r.FormValue("from"), r.FormValue("to")
+	if from == "" || to == "" {
+		apiError(w, "Illegal strongpath request.  Requires 'from' and 'to' parameters.", http.StatusBadRequest)
+		return
+	}
+	var metric PathMetric
+	metricName := r.FormValue("metric")
+	switch metricName {
+	case "", "bottleneck":
+		metricName, metric = "bottleneck", BottleneckMetric
+	case "inverse":
+		metric = InverseSumMetric
+	default:
+		apiError(w, "Illegal metric parameter.  Use 'bottleneck' or 'inverse'.", http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	for _, cell := range []string{from, to} {
+		if !d.CellSet[cell] {
+			apiError(w, "Unknown cell: "+cell, http.StatusNotFound)
+			return
+		}
+	}
+	path, score, found := d.Connectivity.StrongestPath(from, to, metric)
+	if path == nil {
+		path = []string{}
+	}
+	if math.IsInf(score, 0) {
+		// Path from a cell to itself has no weakest connection.
+		score = 0
+	}
+	writeJSON(w, StrongPathResult{found, path, score, metricName})
+}
+
+// Handler for the connectome collapsed by cell type, returned as a map of
+// pre type to post type to summed strength.  The type of a cell is the part
+// of its name before the last "delim" (default: space), or the first
+// submatch of "regex" if given.
+func (s *Server) apiAggregateHandler(w http.ResponseWriter, r *http.Request) {
+	typeOf := DelimitedType(DefaultTypeDelimiter)
+	if expr := r.FormValue("regex"); expr != "" {
+		re, err := compileRegexp(expr, false)
+		if err != nil {
+			apiError(w, "Illegal regex parameter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		typeOf = RegexpType(re)
+	} else if delim := r.FormValue("delim"); delim != "" {
+		typeOf = DelimitedType(delim)
+	}
+	writeJSON(w, s.currentData().Connectivity.Aggregate(typeOf))
+}
+
+// Handler for an undirected version of the connectome, as a symmetric
+// {"a":{"b":strength}} map.  The "mode" parameter is "sum" (default), which
+// adds the strengths of both directions, or "max", which keeps the
+// stronger.  Self-connections are returned unchanged.
+func (s *Server) apiUndirectedHandler(w http.ResponseWriter, r *http.Request) {
+	var mode SymmetryMode
+	switch r.FormValue("mode") {
+	case "", "sum":
+		mode = SumSymmetry
+	case "max":
+		mode = MaxSymmetry
+	default:
+		apiError(w, "Illegal mode parameter.  Use 'sum' or 'max'.", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, s.currentData().Connectivity.Symmetrize(mode))
+}
+
+// Handler for all pairs of cells connected in both directions by at least
+// "min_strength" synapses each way.
+func (s *Server) apiReciprocalHandler(w http.ResponseWriter, r *http.Request) {
+	minStrength, err := intParam(r, "min_strength", 0)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, s.currentData().Connectivity.ReciprocalPairs(minStrength))
+}
+
+// Handler for the strongly connected components of the connections with
+// at least "min_strength" synapses, largest first.  Every cell in no cycle
+// is listed as a component of one unless "singletons" is false.
+func (s *Server) apiSCCHandler(w http.ResponseWriter, r *http.Request) {
+	minStrength, err := intParam(r, "min_strength", 0)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	singletons, err := boolParam(r, "singletons", true)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	writeJSON(w, d.Connectivity.StronglyConnectedComponents(d.Cells, minStrength, singletons))
+}
+
+// Handler for all self-connections in order of strength.
+func (s *Server) apiAutapsesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.currentData().Connectivity.SelfConnections())
+}
+
+// NameSuggestions returns up to MaxNameSuggestions cell names starting with
+// prefix, in alphabetical order.  The prefix is literal text, not a search
+// pattern, so wildcards, exclusions and commas in it match only themselves.
+func (d *Dataset) NameSuggestions(prefix string, ignoreCase bool) CellsByName {
+	if ignoreCase {
+		prefix = strings.ToLower(prefix)
+	}
+	names := CellsByName(append([]string(nil), d.Names.WithPrefix(prefix, ignoreCase)...))
+	sort.Sort(names)
+	if len(names) > MaxNameSuggestions {
+		names = names[:MaxNameSuggestions]
+	}
+	return names
+}
+
+// Handler for autocompletion of cell names beginning with "prefix".  The
+// "ci" parameter works as in searches.
+func (s *Server) apiNamesHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := s.searchOptions(r)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, s.currentData().NameSuggestions(r.FormValue("prefix"), opts.IgnoreCase))
+}
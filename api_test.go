@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatchResultLimit(t *testing.T) {
+	cells := make(CellList, 20)
+	for i := range cells {
+		cells[i] = fmt.Sprintf("Cell %02d", i)
+	}
+	nc := NewNamedConnectome()
+	for _, pre := range cells {
+		for _, post := range cells {
+			nc.AddConnection(pre, post, 1)
+		}
+	}
+	const maxResults = 1000
+	s := NewServer(Config{MaxResults: maxResults, SearchCacheSize: DefaultSearchCacheSize})
+	s.SetData([]*Dataset{NewDataset(cells, nc)}, nil)
+	queries := make([]string, MaxBatchQueries)
+	for i := range queries {
+		queries[i] = `{"pre":"*","post":"*"}`
+	}
+	rec := httptest.NewRecorder()
+	body := strings.NewReader("[" + strings.Join(queries, ",") + "]")
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/batch", body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var results []BatchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != MaxBatchQueries {
+		t.Fatalf("got %d result sets, want %d", len(results), MaxBatchQueries)
+	}
+	returned := 0
+	for i, result := range results {
+		returned += len(result.Connections)
+		if result.Total != len(cells)*len(cells) || result.Unfiltered != result.Total {
+			t.Errorf("result %d: total %d, unfiltered %d, want %d", i, result.Total, result.Unfiltered, len(cells)*len(cells))
+		}
+		if truncated := i >= maxResults/(len(cells)*len(cells)); result.Truncated != truncated {
+			t.Errorf("result %d: truncated %t, want %t", i, result.Truncated, truncated)
+		}
+	}
+	if returned != maxResults {
+		t.Errorf("returned %d connections, want %d", returned, maxResults)
+	}
+}
+
+func TestNameSuggestionsLiteralPrefix(t *testing.T) {
+	cells := CellList{"L1 209", "L2 212", "Mi1 215", "*T4", "-Tm2", "a,b"}
+	d := NewDataset(cells, NewNamedConnectome())
+	tests := []struct {
+		prefix     string
+		ignoreCase bool
+		names      []string
+	}{
+		{"L", false, []string{"L1 209", "L2 212"}},
+		{"mi", true, []string{"Mi1 215"}},
+		{"mi", false, nil},
+		{"*", false, []string{"*T4"}},
+		{"*t", true, []string{"*T4"}},
+		{"-", false, []string{"-Tm2"}},
+		{"!L", false, nil},
+		{"a,", false, []string{"a,b"}},
+		{"L1 209,Mi1", false, nil},
+	}
+	for _, test := range tests {
+		names := d.NameSuggestions(test.prefix, test.ignoreCase)
+		if strings.Join(names, "|") != strings.Join(test.names, "|") {
+			t.Errorf("suggestions for %q (ci=%t) = %q, want %q", test.prefix, test.ignoreCase, names, test.names)
+		}
+	}
+}
+
+func TestTopConnectionsN(t *testing.T) {
+	server := newTestServer(t)
+	for _, test := range []struct {
+		query string
+		want  int
+	}{
+		{"", 5},
+		{"?n=2", 2},
+		{"?n=0", 5},
+		{"?n=0&min_strength=3", 3},
+		{"?n=50", 5},
+	} {
+		status, body := fetch(t, server, "/api/top"+test.query)
+		var connections []connectionJSON
+		if status != http.StatusOK {
+			t.Errorf("/api/top%s: status %d %s", test.query, status, body)
+		} else if err := json.Unmarshal([]byte(body), &connections); err != nil {
+			t.Errorf("/api/top%s: %v", test.query, err)
+		} else if len(connections) != test.want {
+			t.Errorf("/api/top%s returned %d connections, want %d", test.query, len(connections), test.want)
+		}
+	}
+	if status, _ := fetch(t, server, "/api/top?n=-1"); status != http.StatusBadRequest {
+		t.Errorf("/api/top?n=-1: status %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestNeighborsN(t *testing.T) {
+	server := newTestServer(t)
+	for _, test := range []struct {
+		query              string
+		outgoing, incoming int
+	}{
+		{"", 1, 3},
+		{"&n=1", 1, 1},
+		{"&n=0", 1, 3},
+		{"&n=0&min_strength=3", 0, 2},
+	} {
+		path := "/api/neighbors?cell=Mi1%20215" + test.query
+		status, body := fetch(t, server, path)
+		var neighbors Neighbors
+		if status != http.StatusOK {
+			t.Errorf("%s: status %d %s", path, status, body)
+		} else if err := json.Unmarshal([]byte(body), &neighbors); err != nil {
+			t.Errorf("%s: %v", path, err)
+		} else if len(neighbors.Outgoing) != test.outgoing || len(neighbors.Incoming) != test.incoming {
+			t.Errorf("%s returned %d outgoing and %d incoming partners, want %d and %d",
+				path, len(neighbors.Outgoing), len(neighbors.Incoming), test.outgoing, test.incoming)
+		}
+	}
+}
+
+func TestBatchEmptyResultCached(t *testing.T) {
+	nc := NewNamedConnectome()
+	nc.AddConnection("L1 209", "Mi1 215", 9)
+	s := NewServer(Config{SearchCacheSize: DefaultSearchCacheSize})
+	s.SetData([]*Dataset{NewDataset(CellList{"L1 209", "Mi1 215", "LC10"}, nc)}, nil)
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		body := strings.NewReader(`[{"pre":"LC10","post":"*"}]`)
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/batch", body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d: %s", i+1, rec.Code, rec.Body)
+		}
+		if !strings.Contains(rec.Body.String(), `"connections":[]`) {
+			t.Errorf("request %d: got %s, want empty connections", i+1, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Default number of searches whose results are cached per loaded dataset.
+const DefaultSearchCacheSize = 256
+
+// searchResult is the outcome of a search kept in a SearchCache.
+type searchResult struct {
+	preMatches, postMatches []string
+	connections             ConnectionList
+}
+
+type cacheEntry struct {
+	key    string
+	result searchResult
+}
+
+// SearchCache holds the results of the most recently used searches, up to
+// a fixed number, evicting the least recently used.  It is safe for
+// concurrent use.  A nil *SearchCache caches nothing.
+type SearchCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+// NewSearchCache returns a cache of up to size searches, or nil, which
+// disables caching, if size is not positive.
+func NewSearchCache(size int) *SearchCache {
+	if size <= 0 {
+		return nil
+	}
+	return &SearchCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// searchKey returns the cache key of a search: the patterns, split as
+// SearchMatches does for the search mode, and the options that choose which connections are
+// found.  The strength window, applied to the cached connections, and
+// options only affecting how results are shown are left out.
+func searchKey(preNames, postNames string, opts SearchOptions) string {
+	split := splitPatterns
+	if opts.Regex {
+		split = splitRegexps
+	}
+	preNames = strings.Join(split(preNames), "\n")
+	postNames = strings.Join(split(postNames), "\n")
+	return fmt.Sprintf("%q %q ci=%t self=%t regex=%t fuzzy=%t",
+		preNames, postNames, opts.IgnoreCase, opts.ExcludeSelf, opts.Regex, opts.Fuzzy)
+}
+
+// get returns the cached result of a search, if any, marking it as the
+// most recently used.  The connections are a copy the caller may reorder.
+func (c *SearchCache) get(key string) (result searchResult, found bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, found := c.entries[key]
+	if !found {
+		return
+	}
+	c.order.MoveToFront(element)
+	result = element.Value.(*cacheEntry).result
+	result.connections = append(ConnectionList(nil), result.connections...)
+	return result, true
+}
+
+// add caches a copy of the result of a search, evicting the least
+// recently used search if the cache is full.
+func (c *SearchCache) add(key string, result searchResult) {
+	if c == nil {
+		return
+	}
+	result.connections = append(ConnectionList(nil), result.connections...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, found := c.entries[key]; found {
+		element.Value.(*cacheEntry).result = result
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key, result})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
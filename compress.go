@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Responses smaller than this many bytes are sent uncompressed.
+const MinCompressBytes = 1024
+
+// gzipWriter buffers the start of a response until it is clear whether the
+// response is large enough and of a compressible type, then either starts
+// gzip compression or passes the response through unchanged.
+type gzipWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     bytes.Buffer
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (gw *gzipWriter) WriteHeader(status int) {
+	gw.status = status
+}
+
+func (gw *gzipWriter) Write(p []byte) (int, error) {
+	if gw.decided {
+		if gw.gz != nil {
+			return gw.gz.Write(p)
+		}
+		return gw.ResponseWriter.Write(p)
+	}
+	gw.buf.Write(p)
+	if gw.buf.Len() >= MinCompressBytes {
+		if err := gw.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide chooses whether to compress based on what has been buffered and
+// flushes the buffer.
+func (gw *gzipWriter) decide() error {
+	gw.decided = true
+	header := gw.Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType(gw.buf.Bytes()))
+	}
+	if gw.buf.Len() >= MinCompressBytes && header.Get("Content-Encoding") == "" &&
+		header.Get("Content-Range") == "" && compressible(header.Get("Content-Type")) {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	}
+	if gw.status != 0 {
+		gw.ResponseWriter.WriteHeader(gw.status)
+	}
+	var err error
+	if gw.gz != nil {
+		_, err = gw.gz.Write(gw.buf.Bytes())
+	} else {
+		_, err = gw.ResponseWriter.Write(gw.buf.Bytes())
+	}
+	gw.buf.Reset()
+	return err
+}
+
+// Close finishes the response, writing any buffered bytes.
+func (gw *gzipWriter) Close() error {
+	if !gw.decided {
+		if err := gw.decide(); err != nil {
+			return err
+		}
+	}
+	if gw.gz != nil {
+		return gw.gz.Close()
+	}
+	return nil
+}
+
+// Flush sends everything written so far to the client, compressed or not,
+// so streamed responses aren't held back until they end.
+func (gw *gzipWriter) Flush() {
+	if !gw.decided {
+		if err := gw.decide(); err != nil {
+			return
+		}
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+func (gw *gzipWriter) Unwrap() http.ResponseWriter { return gw.ResponseWriter }
+
+// compressible returns true for text-like content types.  Images and other
+// already-compressed binary assets are left alone.
+func compressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") ||
+		strings.Contains(contentType, "json") ||
+		strings.Contains(contentType, "xml") ||
+		strings.Contains(contentType, "javascript") ||
+		strings.Contains(contentType, "graphviz")
+}
+
+// compressResponses wraps a handler to gzip large text responses for
+// clients that accept gzip encoding.
+func compressResponses(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.Close()
+		handler.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip returns true if the request's Accept-Encoding allows gzip,
+// i.e., lists it without a quality value of 0.
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(encoding, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(key) != "q" {
+				continue
+			}
+			var err error
+			if quality, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				return false
+			}
+		}
+		return quality > 0
+	}
+	return false
+}
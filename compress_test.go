@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressResponses(t *testing.T) {
+	body := strings.Repeat("L1 209,Mi1 215,9\n", 200)
+	handler := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Write([]byte(body))
+	}))
+	tests := []struct {
+		acceptEncoding string
+		gzip           bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip", true},
+		{"GZIP", true},
+		{"gzip;q=0.5", true},
+		{"gzip; q=1", true},
+		{"gzip;q=0", false},
+		{"gzip;q=0.0", false},
+		{"gzip;q=0.00", false},
+		{"gzip; q=0", false},
+		{"gzip ; q = 0", false},
+		{"gzip;q=bad", false},
+		{"deflate, gzip;q=0", false},
+		{"gzipper", false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/search", nil)
+		if test.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if gzipped := rec.Header().Get("Content-Encoding") == "gzip"; gzipped != test.gzip {
+			t.Errorf("Accept-Encoding %q: gzipped %t, want %t", test.acceptEncoding, gzipped, test.gzip)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// NamedConnectome holds strength of connections between two bodies
+// that are identified using names (strings) instead of body ids as
+// in the Connectome type.  Names are interned to small integer ids so
+// each name is stored once no matter how many connections it has.
+// Connections are indexed both by pre (edges) and by post (incoming), so
+// either end of a connection can be looked up without a full scan.
+type NamedConnectome struct {
+	ids      map[string]int
+	names    []string
+	edges    map[int]map[int]int
+	incoming map[int]map[int]int
+
+	vectors vectorCache
+}
+
+// NewNamedConnectome returns an empty connectome.
+func NewNamedConnectome() *NamedConnectome {
+	return &NamedConnectome{
+		ids:      make(map[string]int),
+		edges:    make(map[int]map[int]int),
+		incoming: make(map[int]map[int]int),
+	}
+}
+
+// intern returns the id for a name, assigning a new one if needed.
+func (nc *NamedConnectome) intern(name string) int {
+	id, found := nc.ids[name]
+	if !found {
+		id = len(nc.names)
+		nc.ids[name] = id
+		nc.names = append(nc.names, name)
+	}
+	return id
+}
+
+// GetConnection returns a (pre, post) strength and 'found' bool.
+func (nc *NamedConnectome) ConnectionStrength(pre, post string) (strength int, found bool) {
+	preID, found := nc.ids[pre]
+	if !found {
+		return
+	}
+	postID, found := nc.ids[post]
+	if !found {
+		return
+	}
+	strength = nc.edges[preID][postID]
+	found = strength != 0
+	return
+}
+
+// Strength returns the (pre, post) strength, or 0 if not connected.
+func (nc *NamedConnectome) Strength(pre, post string) int {
+	strength, _ := nc.ConnectionStrength(pre, post)
+	return strength
+}
+
+// AddConnection adds a (pre, post) connection of given strength
+// to a connectome.
+func (nc *NamedConnectome) AddConnection(pre, post string, strength int) {
+	preID, postID := nc.intern(pre), nc.intern(post)
+	connections, found := nc.edges[preID]
+	if !found {
+		connections = make(map[int]int)
+		nc.edges[preID] = connections
+	}
+	connections[postID] += strength
+	sources, found := nc.incoming[postID]
+	if !found {
+		sources = make(map[int]int)
+		nc.incoming[postID] = sources
+	}
+	sources[preID] += strength
+}
+
+// EachConnection calls fn for every nonzero connection, in no particular
+// order.
+func (nc *NamedConnectome) EachConnection(fn func(pre, post string, strength int)) {
+	for preID, connections := range nc.edges {
+		for postID, strength := range connections {
+			if strength != 0 {
+				fn(nc.names[preID], nc.names[postID], strength)
+			}
+		}
+	}
+}
+
+// EachOutgoing calls fn for every nonzero connection from pre, in no
+// particular order.
+func (nc *NamedConnectome) EachOutgoing(pre string, fn func(post string, strength int)) {
+	preID, found := nc.ids[pre]
+	if !found {
+		return
+	}
+	for postID, strength := range nc.edges[preID] {
+		if strength != 0 {
+			fn(nc.names[postID], strength)
+		}
+	}
+}
+
+// EachIncoming calls fn for every nonzero connection onto post, in no
+// particular order.  It reads the reverse index kept by AddConnection, so
+// only the cells actually connecting to post are visited.
+func (nc *NamedConnectome) EachIncoming(post string, fn func(pre string, strength int)) {
+	postID, found := nc.ids[post]
+	if !found {
+		return
+	}
+	for preID, strength := range nc.incoming[postID] {
+		if strength != 0 {
+			fn(nc.names[preID], strength)
+		}
+	}
+}
+
+// HasOutgoing returns true if the cell has any connection with it as pre.
+func (nc *NamedConnectome) HasOutgoing(cell string) bool {
+	id, found := nc.ids[cell]
+	return found && len(nc.edges[id]) > 0
+}
+
+// NumPresynaptic returns the number of cells with any outgoing connection.
+func (nc *NamedConnectome) NumPresynaptic() int {
+	return len(nc.edges)
+}
+
+// MarshalJSON encodes the connectome as {"pre":{"post":strength}}.
+func (nc *NamedConnectome) MarshalJSON() ([]byte, error) {
+	nested := make(map[string]map[string]int, len(nc.edges))
+	nc.EachConnection(func(pre, post string, strength int) {
+		if nested[pre] == nil {
+			nested[pre] = make(map[string]int)
+		}
+		nested[pre][post] = strength
+	})
+	return json.Marshal(nested)
+}
+
+// CellNames returns the names of all cells in any connection, sorted.
+func (nc *NamedConnectome) CellNames() CellList {
+	set := make(map[string]bool, len(nc.names))
+	nc.EachConnection(func(pre, post string, strength int) {
+		set[pre] = true
+		set[post] = true
+	})
+	names := make(CellsByName, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Sort(names)
+	return CellList(names)
+}
+
+// NumConnections returns the number of nonzero (pre, post) connections.
+func (nc *NamedConnectome) NumConnections() (num int) {
+	nc.EachConnection(func(pre, post string, strength int) {
+		num++
+	})
+	return
+}
+
+// AllConnections returns every nonzero connection in the connectome,
+// unsorted.
+func (nc *NamedConnectome) AllConnections() ConnectionList {
+	connections := make(ConnectionList, 0, len(nc.edges))
+	nc.EachConnection(func(pre, post string, strength int) {
+		connections = append(connections, Connection{pre, post, strength})
+	})
+	return connections
+}
+
+// OutgoingConnections returns every connection from the given presynaptic
+// cell, sorted by strength.
+func (nc *NamedConnectome) OutgoingConnections(pre string) ConnectionList {
+	connections := make(ConnectionList, 0)
+	nc.EachOutgoing(pre, func(post string, strength int) {
+		connections = append(connections, Connection{pre, post, strength})
+	})
+	connections.SortByStrength()
+	return connections
+}
+
+// OutDegree returns the total number of synapses from the given cell, i.e.,
+// the sum of its row in the connectivity matrix.  A self-connection is
+// counted once here and once in InDegree.
+func (nc *NamedConnectome) OutDegree(cell string) (total int) {
+	nc.EachOutgoing(cell, func(post string, strength int) {
+		total += strength
+	})
+	return
+}
+
+// InDegree returns the total number of synapses onto the given cell, i.e.,
+// the sum of its column in the connectivity matrix.  A self-connection is
+// counted once here and once in OutDegree.
+func (nc *NamedConnectome) InDegree(cell string) (total int) {
+	nc.EachIncoming(cell, func(pre string, strength int) {
+		total += strength
+	})
+	return
+}
+
+// OutPartners returns the number of distinct cells the given cell connects
+// to, including itself if it has a self-connection.
+func (nc *NamedConnectome) OutPartners(cell string) (num int) {
+	nc.EachOutgoing(cell, func(post string, strength int) {
+		num++
+	})
+	return
+}
+
+// InPartners returns the number of distinct cells connecting to the given
+// cell, including itself if it has a self-connection.
+func (nc *NamedConnectome) InPartners(cell string) (num int) {
+	nc.EachIncoming(cell, func(pre string, strength int) {
+		num++
+	})
+	return
+}
+
+// InDegrees returns the InDegree of every cell with any input, computed in
+// a single pass over the connectome.
+func (nc *NamedConnectome) InDegrees() map[string]int {
+	totals := make(map[string]int)
+	nc.EachConnection(func(pre, post string, strength int) {
+		totals[post] += strength
+	})
+	return totals
+}
+
+// SelfConnections returns every autapse, i.e., connection from a cell onto
+// itself, sorted by strength.
+func (nc *NamedConnectome) SelfConnections() ConnectionList {
+	connections := make(ConnectionList, 0)
+	for id, row := range nc.edges {
+		if strength := row[id]; strength > 0 {
+			cell := nc.names[id]
+			connections = append(connections, Connection{cell, cell, strength})
+		}
+	}
+	connections.SortByStrength()
+	return connections
+}
+
+// IncomingConnections returns every connection onto the given postsynaptic
+// cell, sorted by strength.
+func (nc *NamedConnectome) IncomingConnections(post string) ConnectionList {
+	connections := make(ConnectionList, 0)
+	nc.EachIncoming(post, func(pre string, strength int) {
+		connections = append(connections, Connection{pre, post, strength})
+	})
+	connections.SortByStrength()
+	return connections
+}
+
+// Transpose returns a new connectome with every connection reversed, so
+// that the result's rows are the original's columns: the result's strength
+// from post to pre is the original's strength from pre to post.  Cell names
+// keep their ids, and transposing twice yields an equal connectome.
+func (nc *NamedConnectome) Transpose() *NamedConnectome {
+	transposed := &NamedConnectome{
+		ids:      make(map[string]int, len(nc.ids)),
+		names:    make([]string, len(nc.names)),
+		edges:    make(map[int]map[int]int),
+		incoming: make(map[int]map[int]int),
+	}
+	copy(transposed.names, nc.names)
+	for name, id := range nc.ids {
+		transposed.ids[name] = id
+	}
+	for preID, connections := range nc.edges {
+		for postID, strength := range connections {
+			if strength == 0 {
+				continue
+			}
+			row, found := transposed.edges[postID]
+			if !found {
+				row = make(map[int]int)
+				transposed.edges[postID] = row
+			}
+			row[preID] = strength
+		}
+	}
+	for postID, sources := range transposed.edges {
+		for preID, strength := range sources {
+			column, found := transposed.incoming[preID]
+			if !found {
+				column = make(map[int]int)
+				transposed.incoming[preID] = column
+			}
+			column[postID] = strength
+		}
+	}
+	return transposed
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// writeSyntheticEdgeList writes a seeded random edge list of the given
+// number of cells, each connected to partners others, and returns its name.
+func writeSyntheticEdgeList(b *testing.B, cells, partners int) string {
+	b.Helper()
+	filename := filepath.Join(b.TempDir(), "edges.csv")
+	file, err := os.Create(filename)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer file.Close()
+	out := bufio.NewWriter(file)
+	rng := rand.New(rand.NewSource(1))
+	for pre := 0; pre < cells; pre++ {
+		for i := 0; i < partners; i++ {
+			fmt.Fprintf(out, "Cell %05d,Cell %05d,%d\n", pre, rng.Intn(cells), 1+rng.Intn(20))
+		}
+	}
+	if err := out.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	return filename
+}
+
+// reportRetained reports the heap still in use by the result of one more
+// call of load, beyond the allocations counted by ReportAllocs.
+func reportRetained(b *testing.B, load func() any) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	result := load()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(result)
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc), "retained-B")
+}
+
+func BenchmarkLoadMatrix379(b *testing.B) {
+	opts := CSVOptions{}
+	cells, err := ReadCellsCSV(DefaultCellsFilename, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	load := func() any {
+		connects, err := ReadConnectionsCSV(cells, DefaultConnectivityFilename, opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return connects
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		load()
+	}
+	b.StopTimer()
+	reportRetained(b, load)
+}
+
+func BenchmarkLoadEdgeList10k(b *testing.B) {
+	filename := writeSyntheticEdgeList(b, 10000, 30)
+	load := func() any {
+		connects, err := ReadEdgeListCSV(filename, CSVOptions{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		return connects
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		load()
+	}
+	b.StopTimer()
+	reportRetained(b, load)
+}
+
+func TestTranspose(t *testing.T) {
+	nc := NewNamedConnectome()
+	nc.AddConnection("A", "B", 3)
+	nc.AddConnection("B", "C", 5)
+	nc.AddConnection("C", "A", 1)
+	nc.AddConnection("A", "A", 2)
+	transposed := nc.Transpose()
+	for _, test := range []struct {
+		pre, post string
+		strength  int
+	}{
+		{"B", "A", 3},
+		{"C", "B", 5},
+		{"A", "C", 1},
+		{"A", "A", 2},
+		{"A", "B", 0},
+		{"B", "C", 0},
+	} {
+		if got := transposed.Strength(test.pre, test.post); got != test.strength {
+			t.Errorf("transposed strength %s -> %s = %d, want %d", test.pre, test.post, got, test.strength)
+		}
+	}
+	original, twice := nc.AllConnections(), transposed.Transpose().AllConnections()
+	original.SortByStrength()
+	twice.SortByStrength()
+	if !reflect.DeepEqual(twice, original) {
+		t.Errorf("transposing twice gave %v, want %v", twice, original)
+	}
+}
+
+func TestIncomingMatchesScan(t *testing.T) {
+	opts := CSVOptions{}
+	cells, err := ReadCellsCSV(DefaultCellsFilename, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, err := ReadConnectionsCSV(cells, DefaultConnectivityFilename, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.AddConnection(cells[0], cells[1], 7)
+	for _, connectome := range []*NamedConnectome{nc, nc.Transpose()} {
+		scanned := make(map[string]ConnectionList)
+		connectome.EachConnection(func(pre, post string, strength int) {
+			scanned[post] = append(scanned[post], Connection{pre, post, strength})
+		})
+		for _, post := range cells {
+			want := scanned[post]
+			if want == nil {
+				want = ConnectionList{}
+			}
+			want.SortByStrength()
+			if got := connectome.IncomingConnections(post); !reflect.DeepEqual(got, want) {
+				t.Fatalf("incoming connections onto %s = %v, want %v", post, got, want)
+			}
+		}
+	}
+}
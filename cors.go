@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Response headers a cross-origin script may read, in addition to the
+// CORS-safelisted ones.
+const corsExposedHeaders = "X-Total-Count, X-Prefix-Terms, X-Total-Synapses, X-Unfiltered-Count, X-Request-ID"
+
+// corsPath returns true if cross-origin requests may be allowed for the
+// path.  Only the JSON API and exports are shared, never the HTML pages.
+func corsPath(path string) bool {
+	return strings.HasPrefix(path, WebAPIPath) || strings.HasPrefix(path, "/export/")
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for a
+// request's origin given the comma-separated allowed origins, or "" if the
+// origin isn't allowed.  An allowed origin of "*" allows any origin.
+func allowedOrigin(allowed []string, origin string) string {
+	for _, o := range allowed {
+		if o == "*" {
+			return "*"
+		}
+		if o == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
+// allowCORS adds CORS headers to API and export responses for requests
+// from the given comma-separated origins, and answers preflight OPTIONS
+// requests for those paths itself.
+func allowCORS(origins string, handler http.Handler) http.Handler {
+	var allowed []string
+	for _, origin := range strings.Split(origins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowed = append(allowed, origin)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !corsPath(r.URL.Path) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allow := allowedOrigin(allowed, origin)
+		if allow == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allow)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Number of CSV rows or NDJSON lines written between flushes to the client.
+const csvFlushRows = 1000
+
+// Maximum number of cells in an /export/submatrix, whose matrix has the
+// square of that many entries.
+const MaxSubmatrixCells = 2000
+
+// writeConnectionsCSV streams connections as a CSV attachment with a
+// "pre,post,strength" header.
+func writeConnectionsCSV(w http.ResponseWriter, filename string, connections ConnectionList) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(filename))
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write([]string{"pre", "post", "strength"})
+	for i, connection := range connections {
+		csvWriter.Write([]string{connection.pre, connection.post, strconv.Itoa(connection.strength)})
+		if i%csvFlushRows == csvFlushRows-1 {
+			csvWriter.Flush()
+		}
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		slog.Error("writing CSV response", "err", err)
+	}
+}
+
+// exportConnectome returns the connectome to export, pruned to the
+// connections making up at least the fraction of their postsynaptic
+// cell's input given by the "prune" parameter, if any.
+func exportConnectome(r *http.Request, d *Dataset) (*NamedConnectome, error) {
+	fraction, err := floatParam(r, "prune", 0)
+	if err != nil {
+		return nil, err
+	}
+	if fraction < 0 || fraction > 1 {
+		return nil, fmt.Errorf("parameter 'prune' must be between 0 and 1, got %g", fraction)
+	}
+	if fraction == 0 {
+		return d.Connectivity, nil
+	}
+	return d.Connectivity.Prune(fraction), nil
+}
+
+// xmlEscape returns s escaped for use in XML text or attribute values.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Handler for a GraphML document of the whole connectome with a node for
+// each cell and a directed edge weighted by strength for each connection.
+// An optional "min_strength" drops weaker connections, and "prune" drops
+// connections below that fraction of their postsynaptic cell's input.
+func (s *Server) exportGraphMLHandler(w http.ResponseWriter, r *http.Request) {
+	minStrength, err := intParam(r, "min_strength", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	nc, err := exportConnectome(r, d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+	fmt.Fprint(out, xml.Header)
+	fmt.Fprint(out, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`+"\n")
+	fmt.Fprint(out, `  <key id="strength" for="edge" attr.name="strength" attr.type="int"/>`+"\n")
+	fmt.Fprint(out, `  <graph id="connectome" edgedefault="directed">`+"\n")
+	for _, cell := range d.Cells {
+		fmt.Fprintf(out, "    <node id=\"%s\"/>\n", xmlEscape(cell))
+	}
+	for _, pre := range d.Cells {
+		for _, post := range nc.neighbors(pre, Outgoing, 0) {
+			strength := nc.Strength(pre, post)
+			if strength < minStrength {
+				continue
+			}
+			fmt.Fprintf(out, "    <edge source=\"%s\" target=\"%s\"><data key=\"strength\">%d</data></edge>\n",
+				xmlEscape(pre), xmlEscape(post), strength)
+		}
+	}
+	fmt.Fprint(out, "  </graph>\n</graphml>\n")
+}
+
+// Handler streaming every nonzero connection as newline-delimited JSON,
+// one {"pre":...,"post":...,"strength":...} object per line, ordered by pre
+// then post name.  Lines are flushed to the client as they are written
+// rather than building the whole response in memory.  Honors "prune" like
+// the GraphML export.
+func (s *Server) exportNDJSONHandler(w http.ResponseWriter, r *http.Request) {
+	d := s.currentData()
+	nc, err := exportConnectome(r, d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+	encoder := json.NewEncoder(out)
+	flusher := http.NewResponseController(w)
+	lines := 0
+	for _, pre := range d.Cells.Sorted() {
+		for _, post := range nc.neighbors(pre, Outgoing, 0) {
+			connection := Connection{pre, post, nc.Strength(pre, post)}
+			if err := encoder.Encode(connection); err != nil {
+				slog.Error("writing NDJSON response", "err", err)
+				return
+			}
+			if lines++; lines%csvFlushRows == 0 {
+				out.Flush()
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// OrderedMatches returns the cells matching comma-separated patterns in the
+// order the patterns are given, with the names matched by each wildcard
+// pattern in alphabetical order and every cell listed once.  Exclusion
+// patterns apply to the whole list.
+func (d *Dataset) OrderedMatches(patterns string) ([]string, error) {
+	var includes, exclusions []string
+	for _, pattern := range splitPatterns(patterns) {
+		if isExclusion(pattern) {
+			exclusions = append(exclusions, pattern)
+		} else {
+			includes = append(includes, pattern)
+		}
+	}
+	if err := d.resolveBodyIDs(includes); err != nil {
+		return nil, err
+	}
+	if err := d.resolveBodyIDs(exclusions); err != nil {
+		return nil, err
+	}
+	var cells []string
+	seen := make(map[string]bool)
+	for _, pattern := range includes {
+		for _, cell := range sortedNames(d.Names.Matching(append([]string{pattern}, exclusions...), false)) {
+			if !seen[cell] {
+				seen[cell] = true
+				cells = append(cells, cell)
+			}
+		}
+	}
+	return cells, nil
+}
+
+// Handler for the dense connectivity matrix among the cells matching the
+// comma-separated "cells" patterns, as a CSV file whose first row and
+// column label the cells in the order given.  Entry (i, j) is the strength
+// from the i-th cell to the j-th cell, or 0.  Honors "prune" like the
+// GraphML export.
+func (s *Server) exportSubmatrixHandler(w http.ResponseWriter, r *http.Request) {
+	patterns := r.FormValue("cells")
+	if patterns == "" {
+		http.Error(w, "Illegal export request.  Requires 'cells' parameter.", http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	cells, err := d.OrderedMatches(patterns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(cells) == 0 {
+		http.Error(w, "No cell names match: "+patterns, http.StatusNotFound)
+		return
+	}
+	if len(cells) > MaxSubmatrixCells {
+		http.Error(w, fmt.Sprintf("Too many cells for a submatrix: %d match, max %d.",
+			len(cells), MaxSubmatrixCells), http.StatusBadRequest)
+		return
+	}
+	nc, err := exportConnectome(r, d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="submatrix.csv"`)
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write(append([]string{""}, cells...))
+	row := make([]string, len(cells)+1)
+	for _, pre := range cells {
+		row[0] = pre
+		for j, post := range cells {
+			row[j+1] = strconv.Itoa(nc.Strength(pre, post))
+		}
+		csvWriter.Write(row)
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		slog.Error("writing CSV response", "err", err)
+	}
+}
+
+// dotQuote returns s as a quoted Graphviz DOT identifier.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// neighborhoodRequest parses the "cell", "depth", "min_strength" and
+// "prune" parameters of a neighborhood export and returns the connectome
+// to export and the cells in the neighborhood.  On bad parameters it
+// writes an error response and returns ok as false.
+func (s *Server) neighborhoodRequest(w http.ResponseWriter, r *http.Request, defaultDepth int) (nc *NamedConnectome, cells []string, minStrength int, ok bool) {
+	cell := r.FormValue("cell")
+	if cell == "" {
+		http.Error(w, "Illegal export request.  Requires 'cell' parameter.", http.StatusBadRequest)
+		return
+	}
+	depth, err := intParam(r, "depth", defaultDepth)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if depth < 0 || depth > MaxPathHops {
+		http.Error(w, fmt.Sprintf("Parameter 'depth' must be between 0 and %d.", MaxPathHops),
+			http.StatusBadRequest)
+		return
+	}
+	minStrength, err = intParam(r, "min_strength", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d := s.currentData()
+	if !d.CellSet[cell] {
+		http.Error(w, "Unknown cell: "+cell, http.StatusNotFound)
+		return
+	}
+	if nc, err = exportConnectome(r, d); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cells = nc.Neighborhood(cell, depth, BothDirections, minStrength)
+	return nc, cells, minStrength, true
+}
+
+// Handler for a Graphviz DOT digraph of the neighborhood around "cell":
+// all cells within "depth" connections in either direction (default 1)
+// and the connections among them of at least "min_strength", labeled by
+// strength.
+func (s *Server) exportDOTHandler(w http.ResponseWriter, r *http.Request) {
+	nc, cells, minStrength, ok := s.neighborhoodRequest(w, r, 1)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/vnd.graphviz")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+	fmt.Fprintf(out, "digraph %s {\n", dotQuote(cells[0]))
+	for _, name := range cells {
+		fmt.Fprintf(out, "  %s;\n", dotQuote(name))
+	}
+	for _, connection := range nc.Subgraph(cells, minStrength) {
+		fmt.Fprintf(out, "  %s -> %s [label=\"%d\"];\n",
+			dotQuote(connection.pre), dotQuote(connection.post), connection.strength)
+	}
+	fmt.Fprint(out, "}\n")
+}
+
+type cytoscapeNode struct {
+	Data struct {
+		ID    string `json:"id"`
+		Label string `json:"label"`
+	} `json:"data"`
+}
+
+type cytoscapeEdge struct {
+	Data struct {
+		ID     string `json:"id"`
+		Source string `json:"source"`
+		Target string `json:"target"`
+		Weight int    `json:"weight"`
+	} `json:"data"`
+}
+
+// CytoscapeElements is the Cytoscape.js elements format for a graph.
+type CytoscapeElements struct {
+	Nodes []cytoscapeNode `json:"nodes"`
+	Edges []cytoscapeEdge `json:"edges"`
+}
+
+// Handler for Cytoscape.js elements of the neighborhood around "cell",
+// with the same parameters as the DOT export but a default depth of 2.
+// Edge ids are "pre->post" so they are stable across requests.
+func (s *Server) exportCytoscapeHandler(w http.ResponseWriter, r *http.Request) {
+	nc, cells, minStrength, ok := s.neighborhoodRequest(w, r, 2)
+	if !ok {
+		return
+	}
+	connections := nc.Subgraph(cells, minStrength)
+	elements := CytoscapeElements{
+		Nodes: make([]cytoscapeNode, len(cells)),
+		Edges: make([]cytoscapeEdge, len(connections)),
+	}
+	for i, cell := range cells {
+		elements.Nodes[i].Data.ID = cell
+		elements.Nodes[i].Data.Label = cell
+	}
+	for i, connection := range connections {
+		edge := &elements.Edges[i].Data
+		edge.ID = connection.pre + "->" + connection.post
+		edge.Source = connection.pre
+		edge.Target = connection.post
+		edge.Weight = connection.strength
+	}
+	writeJSON(w, elements)
+}
+
+// Degrees returns the Degree of each of the given cells, in alphabetical
+// order, computed in a single pass over the connectome.
+func (nc *NamedConnectome) Degrees(list CellList) []Degree {
+	cells := list.Sorted()
+	degrees := make([]Degree, len(cells))
+	index := make(map[string]*Degree, len(cells))
+	for i, cell := range cells {
+		degrees[i].Cell = cell
+		index[cell] = &degrees[i]
+	}
+	nc.EachConnection(func(pre, post string, strength int) {
+		if degree := index[pre]; degree != nil {
+			degree.OutSynapses += strength
+			degree.OutPartners++
+		}
+		if degree := index[post]; degree != nil {
+			degree.InSynapses += strength
+			degree.InPartners++
+		}
+	})
+	return degrees
+}
+
+// degreeColumns are the columns of the cells CSV export after the name,
+// in order, each with the Degree field it holds.
+var degreeColumns = []struct {
+	name  string
+	value func(Degree) int
+}{
+	{"out_synapses", func(degree Degree) int { return degree.OutSynapses }},
+	{"in_synapses", func(degree Degree) int { return degree.InSynapses }},
+	{"out_partners", func(degree Degree) int { return degree.OutPartners }},
+	{"in_partners", func(degree Degree) int { return degree.InPartners }},
+}
+
+// Handler for a CSV file with one row per cell giving its synapse totals
+// and partner counts in each direction.  Rows are sorted by name unless
+// "sort" names one of the count columns, which sorts largest first with
+// ties by name.  Honors "prune" like the GraphML export.
+func (s *Server) exportCellsHandler(w http.ResponseWriter, r *http.Request) {
+	d := s.currentData()
+	nc, err := exportConnectome(r, d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	degrees := nc.Degrees(d.Cells)
+	if order := r.FormValue("sort"); order != "" && order != "name" {
+		var value func(Degree) int
+		var names []string
+		for _, column := range degreeColumns {
+			if column.name == order {
+				value = column.value
+			}
+			names = append(names, column.name)
+		}
+		if value == nil {
+			http.Error(w, fmt.Sprintf("Parameter 'sort' must be name or one of %s, got %q.",
+				strings.Join(names, ", "), order), http.StatusBadRequest)
+			return
+		}
+		sort.SliceStable(degrees, func(i, j int) bool {
+			return value(degrees[i]) > value(degrees[j])
+		})
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="cells.csv"`)
+	csvWriter := csv.NewWriter(w)
+	row := []string{"name"}
+	for _, column := range degreeColumns {
+		row = append(row, column.name)
+	}
+	csvWriter.Write(row)
+	for i, degree := range degrees {
+		row[0] = degree.Cell
+		for j, column := range degreeColumns {
+			row[j+1] = strconv.Itoa(column.value(degree))
+		}
+		csvWriter.Write(row)
+		if i%csvFlushRows == csvFlushRows-1 {
+			csvWriter.Flush()
+		}
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		slog.Error("writing CSV response", "err", err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExportSubmatrixCellLimit(t *testing.T) {
+	cells := make(CellList, MaxSubmatrixCells+1)
+	for i := range cells {
+		cells[i] = fmt.Sprintf("Cell %05d", i)
+	}
+	nc := NewNamedConnectome()
+	nc.AddConnection(cells[0], cells[1], 3)
+	s := NewServer(Config{})
+	s.SetData([]*Dataset{NewDataset(cells, nc)}, nil)
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/export/submatrix?cells=Cell%2000000,Cell%2000001", http.StatusOK},
+		{"/export/submatrix?cells=Cell%2000*", http.StatusOK},
+		{"/export/submatrix?cells=*", http.StatusBadRequest},
+		{"/export/submatrix?cells=Cell*,-Cell%2000000", http.StatusOK},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.status {
+			t.Errorf("GET %s: status %d, want %d", test.path, rec.Code, test.status)
+		}
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/JaneliaSciComp/medulla_one_column
 
-go 1.20
+go 1.21
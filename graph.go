@@ -0,0 +1,551 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Maximum number of hops allowed in path searches to avoid runaway
+// traversals on dense graphs.
+const MaxPathHops = 10
+
+// ShortestPath does a breadth-first search along connections from pre to
+// post, returning the sequence of cells on a path with the fewest hops and
+// whether such a path exists within maxHops.  Among equally short paths,
+// the one through alphabetically earlier cells is returned.
+func (nc *NamedConnectome) ShortestPath(pre, post string, maxHops int) ([]string, bool) {
+	if maxHops > MaxPathHops {
+		maxHops = MaxPathHops
+	}
+	if pre == post {
+		return []string{pre}, true
+	}
+	previous := map[string]string{pre: ""}
+	frontier := []string{pre}
+	for hop := 0; hop < maxHops && len(frontier) > 0; hop++ {
+		var next []string
+		for _, cell := range frontier {
+			for _, partner := range nc.neighbors(cell, Outgoing, 0) {
+				if _, visited := previous[partner]; visited {
+					continue
+				}
+				previous[partner] = cell
+				if partner == post {
+					path := []string{post}
+					for cell := previous[post]; cell != ""; cell = previous[cell] {
+						path = append(path, cell)
+					}
+					for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+						path[i], path[j] = path[j], path[i]
+					}
+					return path, true
+				}
+				next = append(next, partner)
+			}
+		}
+		frontier = next
+	}
+	return nil, false
+}
+
+// PathMetric selects how StrongestPath scores a path from its connection
+// strengths.
+type PathMetric int
+
+const (
+	// BottleneckMetric scores a path by its weakest connection, higher
+	// being better.
+	BottleneckMetric PathMetric = iota
+
+	// InverseSumMetric scores a path by the sum of 1/strength over its
+	// connections, lower being better.
+	InverseSumMetric
+)
+
+// better returns true if path score a is preferable to b.
+func (metric PathMetric) better(a, b float64) bool {
+	if metric == BottleneckMetric {
+		return a > b
+	}
+	return a < b
+}
+
+// extend returns the score of a path with given score after adding a
+// connection of given strength.
+func (metric PathMetric) extend(score float64, strength int) float64 {
+	if metric == BottleneckMetric {
+		return math.Min(score, float64(strength))
+	}
+	return score + 1/float64(strength)
+}
+
+// start returns the score of an empty path.
+func (metric PathMetric) start() float64 {
+	if metric == BottleneckMetric {
+		return math.Inf(1)
+	}
+	return 0
+}
+
+type pathItem struct {
+	cell  string
+	score float64
+	hops  int
+}
+
+// pathQueue is a priority queue of partial paths, best first.
+type pathQueue struct {
+	items  []pathItem
+	metric PathMetric
+}
+
+func (q pathQueue) Len() int      { return len(q.items) }
+func (q pathQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+func (q pathQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if a.score != b.score {
+		return q.metric.better(a.score, b.score)
+	}
+	if a.hops != b.hops {
+		return a.hops < b.hops
+	}
+	return a.cell < b.cell
+}
+func (q *pathQueue) Push(x interface{}) { q.items = append(q.items, x.(pathItem)) }
+func (q *pathQueue) Pop() interface{} {
+	item := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return item
+}
+
+// StrongestPath uses Dijkstra's algorithm to find the best-scoring path
+// from pre to post under the given metric, returning the cells along it,
+// its score, and whether any path exists.  Ties in score are broken in
+// favor of fewer hops, then of alphabetically earlier cells.
+func (nc *NamedConnectome) StrongestPath(pre, post string, metric PathMetric) (path []string, score float64, found bool) {
+	previous := map[string]string{pre: ""}
+	best := map[string]pathItem{pre: {pre, metric.start(), 0}}
+	settled := make(map[string]bool)
+	queue := &pathQueue{metric: metric}
+	heap.Push(queue, best[pre])
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(pathItem)
+		if settled[item.cell] {
+			continue
+		}
+		settled[item.cell] = true
+		if item.cell == post {
+			for cell := post; cell != ""; cell = previous[cell] {
+				path = append(path, cell)
+			}
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path, item.score, true
+		}
+		for _, partner := range nc.neighbors(item.cell, Outgoing, 0) {
+			if settled[partner] {
+				continue
+			}
+			candidate := pathItem{partner, metric.extend(item.score, nc.Strength(item.cell, partner)), item.hops + 1}
+			current, seen := best[partner]
+			if !seen || metric.better(candidate.score, current.score) ||
+				(candidate.score == current.score && candidate.hops < current.hops) {
+				best[partner] = candidate
+				previous[partner] = item.cell
+				heap.Push(queue, candidate)
+			}
+		}
+	}
+	return nil, 0, false
+}
+
+// SymmetryMode selects how Symmetrize combines the two directions of a
+// connection.
+type SymmetryMode int
+
+const (
+	// SumSymmetry weights an undirected edge by the sum of both directions.
+	SumSymmetry SymmetryMode = iota
+
+	// MaxSymmetry weights an undirected edge by the stronger direction.
+	MaxSymmetry
+)
+
+// Symmetrize returns a new connectome in which the strength from a to b
+// equals the strength from b to a, combining the original strengths of both
+// directions according to mode.  Self-connections are kept unchanged
+// rather than being counted once per direction.
+func (nc *NamedConnectome) Symmetrize(mode SymmetryMode) *NamedConnectome {
+	symmetric := NewNamedConnectome()
+	nc.EachConnection(func(pre, post string, strength int) {
+		if pre == post {
+			symmetric.AddConnection(pre, post, strength)
+			return
+		}
+		reverse := nc.Strength(post, pre)
+		if reverse != 0 && post < pre {
+			// Already added when visiting the (post, pre) connection.
+			return
+		}
+		combined := strength + reverse
+		if mode == MaxSymmetry {
+			combined = max(strength, reverse)
+		}
+		symmetric.AddConnection(pre, post, combined)
+		symmetric.AddConnection(post, pre, combined)
+	})
+	return symmetric
+}
+
+// Prune returns a new connectome with only the connections that make up at
+// least minInputFraction of their postsynaptic cell's total input, i.e.,
+// of the column sum of the connectivity matrix.
+func (nc *NamedConnectome) Prune(minInputFraction float64) *NamedConnectome {
+	inputs := nc.InDegrees()
+	pruned := NewNamedConnectome()
+	nc.EachConnection(func(pre, post string, strength int) {
+		if float64(strength) >= minInputFraction*float64(inputs[post]) {
+			pruned.AddConnection(pre, post, strength)
+		}
+	})
+	return pruned
+}
+
+// Aggregate returns a new connectome in which every cell is replaced by
+// the type key returned by typeOf, summing the strengths of all connections
+// between cells of each pair of types.  Connections between two cells of
+// the same type, including self-connections, are summed into that type's
+// self-connection.
+func (nc *NamedConnectome) Aggregate(typeOf func(cell string) string) *NamedConnectome {
+	aggregated := NewNamedConnectome()
+	nc.EachConnection(func(pre, post string, strength int) {
+		aggregated.AddConnection(typeOf(pre), typeOf(post), strength)
+	})
+	return aggregated
+}
+
+// DelimitedType returns a type key function that strips everything from
+// the last occurrence of delim, e.g., "Mi1 215" -> "Mi1" for a space
+// delimiter.  Names without the delimiter are their own type.
+func DelimitedType(delim string) func(string) string {
+	return func(cell string) string {
+		if i := strings.LastIndex(cell, delim); i > 0 {
+			return cell[:i]
+		}
+		return cell
+	}
+}
+
+// RegexpType returns a type key function that uses the first submatch of
+// re, or the whole match if re has no groups.  Names that don't match are
+// their own type.
+func RegexpType(re *regexp.Regexp) func(string) string {
+	return func(cell string) string {
+		match := re.FindStringSubmatch(cell)
+		switch {
+		case match == nil:
+			return cell
+		case len(match) > 1:
+			return match[1]
+		default:
+			return match[0]
+		}
+	}
+}
+
+// RandomWalk follows outgoing connections from start for up to steps hops,
+// choosing each next cell with probability proportional to the strength
+// of the connection to it.  It returns the cells visited, starting with
+// start, and stops early at a cell with no outgoing connections.  The same
+// rng seed always gives the same walk.
+func (nc *NamedConnectome) RandomWalk(start string, steps int, rng *rand.Rand) (path []string, deadEnd bool) {
+	path = []string{start}
+	cell := start
+	for len(path) <= steps {
+		partners := nc.neighbors(cell, Outgoing, 0)
+		total := 0
+		for _, partner := range partners {
+			total += nc.Strength(cell, partner)
+		}
+		if total == 0 {
+			return path, true
+		}
+		pick := rng.Intn(total)
+		for _, partner := range partners {
+			if pick -= nc.Strength(cell, partner); pick < 0 {
+				cell = partner
+				break
+			}
+		}
+		path = append(path, cell)
+	}
+	return path, false
+}
+
+// Direction selects which connections of a cell to follow.
+type Direction int
+
+const (
+	Outgoing Direction = 1 << iota
+	Incoming
+	BothDirections = Outgoing | Incoming
+)
+
+// neighbors returns the partners of a cell in the given direction that are
+// connected with at least minStrength, in name order.
+func (nc *NamedConnectome) neighbors(cell string, dir Direction, minStrength int) []string {
+	set := make(map[string]bool)
+	add := func(partner string, strength int) {
+		if strength >= minStrength {
+			set[partner] = true
+		}
+	}
+	if dir&Outgoing != 0 {
+		nc.EachOutgoing(cell, add)
+	}
+	if dir&Incoming != 0 {
+		nc.EachIncoming(cell, add)
+	}
+	partners := make([]string, 0, len(set))
+	for partner := range set {
+		partners = append(partners, partner)
+	}
+	sort.Strings(partners)
+	return partners
+}
+
+// Neighborhood does a breadth-first expansion from cell along connections
+// of at least minStrength in the given direction, returning every cell
+// within depth hops in the order visited.  The depth is capped at
+// MaxPathHops.
+func (nc *NamedConnectome) Neighborhood(cell string, depth int, dir Direction, minStrength int) []string {
+	cells, _ := nc.LimitedNeighborhood(cell, depth, dir, minStrength, 0)
+	return cells
+}
+
+// LimitedNeighborhood is like Neighborhood but keeps only the first
+// maxCells cells visited, if maxCells is positive, and also returns how
+// many more cells are within depth hops.
+func (nc *NamedConnectome) LimitedNeighborhood(cell string, depth int, dir Direction, minStrength, maxCells int) (cells []string, truncated int) {
+	if depth > MaxPathHops {
+		depth = MaxPathHops
+	}
+	visited := map[string]bool{cell: true}
+	cells = []string{cell}
+	frontier := []string{cell}
+	for hop := 0; hop < depth && len(frontier) > 0; hop++ {
+		var next []string
+		for _, current := range frontier {
+			for _, partner := range nc.neighbors(current, dir, minStrength) {
+				if !visited[partner] {
+					visited[partner] = true
+					if maxCells > 0 && len(cells) >= maxCells {
+						truncated++
+					} else {
+						cells = append(cells, partner)
+					}
+					next = append(next, partner)
+				}
+			}
+		}
+		frontier = next
+	}
+	return
+}
+
+// Subgraph returns the connections of at least minStrength among the given
+// cells, ordered by the position of the presynaptic cell in cells and then
+// by postsynaptic name.
+func (nc *NamedConnectome) Subgraph(cells []string, minStrength int) ConnectionList {
+	set := make(map[string]bool, len(cells))
+	for _, cell := range cells {
+		set[cell] = true
+	}
+	connections := make(ConnectionList, 0, len(cells))
+	for _, pre := range cells {
+		for _, post := range nc.neighbors(pre, Outgoing, 0) {
+			strength := nc.Strength(pre, post)
+			if set[post] && strength >= minStrength {
+				connections = append(connections, Connection{pre, post, strength})
+			}
+		}
+	}
+	return connections
+}
+
+// SharedPartner is a cell connected to every one of a set of cells, with
+// the strength of its connection to each of them and their total.
+type SharedPartner struct {
+	Cell      string         `json:"cell"`
+	Strengths map[string]int `json:"strengths"`
+	Total     int            `json:"total"`
+}
+
+// CommonTargets returns the postsynaptic partners shared by all the given
+// cells, strongest total first and then by name.
+func (nc *NamedConnectome) CommonTargets(cells []string) []SharedPartner {
+	return nc.commonPartners(cells, Outgoing)
+}
+
+// CommonSources returns the presynaptic partners shared by all the given
+// cells, strongest total first and then by name.  If any of the cells has
+// no input, there are none.
+func (nc *NamedConnectome) CommonSources(cells []string) []SharedPartner {
+	return nc.commonPartners(cells, Incoming)
+}
+
+// commonPartners intersects the partners of the cells in one direction.
+func (nc *NamedConnectome) commonPartners(cells []string, dir Direction) []SharedPartner {
+	shared := make(map[string]*SharedPartner)
+	for i, cell := range cells {
+		found := make(map[string]bool)
+		add := func(partner string, strength int) {
+			if i == 0 {
+				shared[partner] = &SharedPartner{Cell: partner, Strengths: make(map[string]int)}
+			} else if shared[partner] == nil {
+				return
+			}
+			shared[partner].Strengths[cell] = strength
+			shared[partner].Total += strength
+			found[partner] = true
+		}
+		if dir == Outgoing {
+			nc.EachOutgoing(cell, add)
+		} else {
+			nc.EachIncoming(cell, add)
+		}
+		for partner := range shared {
+			if !found[partner] {
+				delete(shared, partner)
+			}
+		}
+	}
+	partners := make([]SharedPartner, 0, len(shared))
+	for _, partner := range shared {
+		partners = append(partners, *partner)
+	}
+	sort.Slice(partners, func(i, j int) bool {
+		if partners[i].Total != partners[j].Total {
+			return partners[i].Total > partners[j].Total
+		}
+		return partners[i].Cell < partners[j].Cell
+	})
+	return partners
+}
+
+// ReciprocalPair is a pair of cells connected in both directions.
+type ReciprocalPair struct {
+	A    string `json:"a"`
+	B    string `json:"b"`
+	AtoB int    `json:"a_to_b"`
+	BtoA int    `json:"b_to_a"`
+}
+
+// ReciprocalPairs returns every pair of distinct cells connected to each
+// other in both directions with at least minStrength each way.  Each pair
+// is reported once with A alphabetically before B, and pairs are ordered
+// by total strength and then by name.
+func (nc *NamedConnectome) ReciprocalPairs(minStrength int) []ReciprocalPair {
+	pairs := make([]ReciprocalPair, 0)
+	nc.EachConnection(func(a, b string, aToB int) {
+		if a >= b || aToB < minStrength {
+			return
+		}
+		bToA, found := nc.ConnectionStrength(b, a)
+		if found && bToA >= minStrength {
+			pairs = append(pairs, ReciprocalPair{a, b, aToB, bToA})
+		}
+	})
+	sort.Slice(pairs, func(i, j int) bool {
+		si, sj := pairs[i].AtoB+pairs[i].BtoA, pairs[j].AtoB+pairs[j].BtoA
+		if si != sj {
+			return si > sj
+		}
+		if pairs[i].A != pairs[j].A {
+			return pairs[i].A < pairs[j].A
+		}
+		return pairs[i].B < pairs[j].B
+	})
+	return pairs
+}
+
+// StronglyConnectedComponents returns the strongly connected components
+// of the given cells under the connections with at least minStrength,
+// found with Tarjan's algorithm: each is a maximal set of cells that can
+// all reach each other.  Cells within a component are in name order, and
+// components are ordered largest first and then by their first cell.
+// Cells in no cycle, not even an autapse of at least minStrength, form
+// components of one, which are left out unless singletons is true.
+func (nc *NamedConnectome) StronglyConnectedComponents(cells []string, minStrength int, singletons bool) [][]string {
+	sorted := make([]string, len(cells))
+	copy(sorted, cells)
+	sort.Strings(sorted)
+
+	index := make([]int, len(nc.names))
+	lowlink := make([]int, len(nc.names))
+	onStack := make([]bool, len(nc.names))
+	var stack []int
+	next := 1
+	components := make([][]string, 0)
+	connected := func(pre, post int) bool {
+		strength := nc.edges[pre][post]
+		return strength != 0 && strength >= minStrength
+	}
+	var connect func(id int)
+	connect = func(id int) {
+		index[id], lowlink[id] = next, next
+		next++
+		stack = append(stack, id)
+		onStack[id] = true
+		for partner := range nc.edges[id] {
+			if !connected(id, partner) {
+				continue
+			}
+			if index[partner] == 0 {
+				connect(partner)
+				lowlink[id] = min(lowlink[id], lowlink[partner])
+			} else if onStack[partner] {
+				lowlink[id] = min(lowlink[id], index[partner])
+			}
+		}
+		if lowlink[id] != index[id] {
+			return
+		}
+		var component []string
+		for {
+			member := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[member] = false
+			component = append(component, nc.names[member])
+			if member == id {
+				break
+			}
+		}
+		if len(component) > 1 || singletons || connected(id, id) {
+			sort.Strings(component)
+			components = append(components, component)
+		}
+	}
+	for _, cell := range sorted {
+		id, found := nc.ids[cell]
+		if !found {
+			if singletons {
+				components = append(components, []string{cell})
+			}
+		} else if index[id] == 0 {
+			connect(id)
+		}
+	}
+	sort.Slice(components, func(i, j int) bool {
+		if len(components[i]) != len(components[j]) {
+			return len(components[i]) > len(components[j])
+		}
+		return components[i][0] < components[j][0]
+	})
+	return components
+}
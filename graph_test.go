@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// sccConnectome has a 3-cycle A->B->C->A, a chain C->D, an autapse on E,
+// a weak 2-cycle F<->G, and cell H in no connection at all.
+func sccConnectome() (*NamedConnectome, []string) {
+	nc := NewNamedConnectome()
+	nc.AddConnection("A", "B", 5)
+	nc.AddConnection("B", "C", 5)
+	nc.AddConnection("C", "A", 5)
+	nc.AddConnection("C", "D", 5)
+	nc.AddConnection("E", "E", 4)
+	nc.AddConnection("F", "G", 1)
+	nc.AddConnection("G", "F", 1)
+	return nc, []string{"H", "G", "F", "E", "D", "C", "B", "A"}
+}
+
+func TestStronglyConnectedComponents(t *testing.T) {
+	nc, cells := sccConnectome()
+	tests := []struct {
+		name        string
+		minStrength int
+		singletons  bool
+		want        [][]string
+	}{
+		{"all cells", 0, true, [][]string{{"A", "B", "C"}, {"F", "G"}, {"D"}, {"E"}, {"H"}}},
+		{"cycles only", 0, false, [][]string{{"A", "B", "C"}, {"F", "G"}, {"E"}}},
+		{"weak cycle dropped", 3, false, [][]string{{"A", "B", "C"}, {"E"}}},
+		{"weak autapse dropped", 5, false, [][]string{{"A", "B", "C"}}},
+	}
+	for _, test := range tests {
+		got := nc.StronglyConnectedComponents(cells, test.minStrength, test.singletons)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestStronglyConnectedComponentsIsolatedCell(t *testing.T) {
+	nc, cells := sccConnectome()
+	listed := 0
+	found := false
+	for _, component := range nc.StronglyConnectedComponents(cells, 0, true) {
+		listed += len(component)
+		if len(component) == 1 && component[0] == "H" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("isolated cell H not listed as a singleton")
+	}
+	if listed != len(cells) {
+		t.Errorf("listed %d cells, want %d", listed, len(cells))
+	}
+}
+
+func TestStronglyConnectedComponentsAutapse(t *testing.T) {
+	nc, cells := sccConnectome()
+	for _, component := range nc.StronglyConnectedComponents(cells, 4, false) {
+		if len(component) == 1 && component[0] == "E" {
+			return
+		}
+	}
+	t.Errorf("cell E with an autapse of strength 4 dropped at min_strength 4")
+}
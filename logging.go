@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type requestLogKey struct{}
+
+// requestLog collects attributes for a request's log entry and the level
+// to log it at, Info unless lowered by logAtDebug.
+type requestLog struct {
+	id    string
+	mu    sync.Mutex
+	attrs []any
+	level slog.Level
+}
+
+// requestID returns the id assigned to the request by logRequests.
+func requestID(r *http.Request) string {
+	if entry, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		return entry.id
+	}
+	return ""
+}
+
+// logAttrs adds key-value attributes to the log entry for the request.
+func logAttrs(r *http.Request, args ...any) {
+	if entry, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		entry.mu.Lock()
+		entry.attrs = append(entry.attrs, args...)
+		entry.mu.Unlock()
+	}
+}
+
+// logAtDebug logs the request's entry at Debug rather than Info level, for
+// routine requests such as static files that would drown out the rest.
+func logAtDebug(r *http.Request) {
+	if entry, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		entry.mu.Lock()
+		entry.level = slog.LevelDebug
+		entry.mu.Unlock()
+	}
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter { return rec.ResponseWriter }
+
+// newRequestID returns a random id for correlating a request's log lines.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Longest client supplied X-Request-ID that is reused.
+const MaxRequestIDLength = 64
+
+// validRequestID returns true if a client supplied request id is short and
+// only letters, digits, '.', '_' or '-', so it can't forge or flood the
+// log fields it is written to.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// logRequests wraps a handler so every request gets an id, returned in the
+// X-Request-ID header, and one structured log line with its method, path,
+// status, elapsed time, and any attributes added via logAttrs, at Info
+// level unless logAtDebug was called.  A client supplied X-Request-ID is
+// reused if it is a validRequestID.
+func logRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		entry := &requestLog{id: id}
+		r = r.WithContext(context.WithValue(r.Context(), requestLogKey{}, entry))
+		rec := &statusRecorder{w, http.StatusOK}
+		handler.ServeHTTP(rec, r)
+
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		args := append([]any{
+			"id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"elapsed", time.Since(start),
+		}, entry.attrs...)
+		slog.Log(r.Context(), entry.level, "request", args...)
+	})
+}
+
+// parseLogLevel returns the slog level named by a -loglevel value: "error",
+// "warn", "info" or "debug".
+func parseLogLevel(name string) (slog.Level, error) {
+	switch name {
+	case "error":
+		return slog.LevelError, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	}
+	return 0, fmt.Errorf("-loglevel must be error, warn, info or debug, not %q", name)
+}
+
+// setupLogging makes a key=value slog handler logging at the given level
+// and above the default for both slog and the standard log package.  Lines
+// from the standard log package are logged at info level.
+func setupLogging(level slog.Level) {
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(handler))
+}
+
+// fatal logs msg and its key-value attributes at error level, so it is
+// shown at every -loglevel, and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
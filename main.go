@@ -36,34 +36,128 @@ package main
 import (
 	//	"bufio"
 	//	"bytes"
+	"crypto/subtle"
+	"embed"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"html/template"
 	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 const helpMessage = `
 web_connectome serves a simple web page that allows visitors to
   query connections between named cell types, possibly using wild-cards.
+  Searches are shareable as links like /search?pre=L1*&post=Mi1*.
 
 Usage: web_connectome [options]
 
       -names      =string   File name of cell names CSV (default: %s)
-      -connect    =string   File name of connectivity CSV (default: %s)
-      -http       =string   Address for HTTP communication
-      -debug      (flag)    Run in debug mode.  Verbose.
+      -connect    =string   File name of connectivity CSV (default: %s).  Give
+                            name=file, more than once, to load several types of
+                            connectome, such as chemical=chem.csv.  Searches pick
+                            one with type=name and default to the first.
+      -connect2   =string   Optional second connectivity CSV, in the same format
+                            and cell order, to compare against with /api/diff.
+      -format     =string   Connectivity CSV format: "matrix" (default) or "edgelist"
+                            of pre,post,strength rows.  With "edgelist", an
+                            empty -names discovers names from the edges.
+      -header     =string   First row of the CSV files: "none" (default), "skip"
+                            a header, or "names" to also check a matrix header
+                            matches the cell names.
+      -delimiter  =string   Field separator of the CSV files, a single character
+                            such as ";" or \t for tabs (default: ",")
+      -collapse-spaces (flag) Collapse runs of whitespace within cell names to
+                            one space.  Names are always trimmed.
+      -float      (flag)    Accept fractional strengths, stored and reported
+                            multiplied by -float-scale and rounded.
+      -float-scale =number  Scale for -float strengths (default: %g)
+      -http       =string   Address for HTTP communication.  A port of 0, as in
+                            :0, picks a free port and prints it.
+      -webdir     =string   Serve web pages from this directory instead of the
+                            copies embedded in the binary, e.g., web_pages
+      -ignorecase (flag)    Match cell names regardless of case by default.
+      -read-timeout  =duration  Max time to read a request (default: %s)
+      -write-timeout =duration  Max time to write a response (default: %s)
+      -idle-timeout  =duration  Max time to keep an idle connection (default: %s)
+      -no-compress (flag)   Never gzip responses.
+      -search-cache =int    Number of recent searches whose results are cached
+                            until the next reload, or 0 to disable (default: %d)
+      -max-results =int     Most connections shown on one HTML results page or
+                            returned by one /api/batch request, or 0 for no
+                            limit (default: %d)
+      -max-body   =int      Largest search request body in bytes, with larger
+                            ones refused, or 0 for no limit (default: %d)
+      -idmap      =string   Optional CSV of id,name rows so searches can use
+                            id:<body id> in place of a cell name.
+      -allow-origin =string Comma-separated origins, or *, allowed to make
+                            cross-origin API and export requests (default: none)
+      -tls-cert   =string   Certificate file for serving HTTPS.  Requires -tls-key.
+      -tls-key    =string   Private key file for serving HTTPS.  Requires -tls-cert.
+      -reload-token =string Token that POSTs to /admin/reload must send as
+                            "Authorization: Bearer <token>".  Without one, only
+                            clients on a loopback address may reload, which
+                            behind a reverse proxy on the same host is every
+                            client, so always set one there.
+      -loglevel   =string   Least severe messages to log: error, warn, info or
+                            debug (default: info)
+      -debug      (flag)    Run in debug mode.  Same as -loglevel debug.
+      -version    (flag)    Print the version and build information, then exit.
   -h, -help       (flag)    Show help message
+
+Unless given as flags, -names, -connect and -http default to the CELLS_FILE,
+  CONNECT_FILE and HTTP_ADDR environment variables when those are set.
 `
 
-const htmlTemplate = `
+// envDefaults lists the flags whose defaults can be overridden by an
+// environment variable, which in turn is overridden by the flag itself.
+var envDefaults = []struct{ flag, env string }{
+	{"names", "CELLS_FILE"},
+	{"connect", "CONNECT_FILE"},
+	{"http", "HTTP_ADDR"},
+}
+
+// applyEnvDefaults sets each flag in envDefaults that wasn't given on the
+// command line from its environment variable, if that is set, and logs
+// where each value came from.
+func applyEnvDefaults() error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, d := range envDefaults {
+		source := "default"
+		if given[d.flag] {
+			source = "-" + d.flag + " flag"
+		} else if value, found := os.LookupEnv(d.env); found && value != "" {
+			if err := flag.Set(d.flag, value); err != nil {
+				return fmt.Errorf("%s: %w", d.env, err)
+			}
+			source = d.env + " environment variable"
+		}
+		log.Printf("Using -%s %q from %s.\n", d.flag, flag.Lookup(d.flag).Value, source)
+	}
+	return nil
+}
+
+var searchTemplate = template.Must(template.New("search").Parse(`
 <!DOCTYPE html>
 <html>
   <head>
@@ -75,53 +169,415 @@ const htmlTemplate = `
   </head>
   <body>
   	<div align="center">
-  		<div align="left"  style="width:80%%">
-%s
+  		<div align="left"  style="width:80%">
+{{if .PrefixTerms}}<p>No cell is named exactly {{range $i, $term := .PrefixTerms}}{{if $i}}, {{end}}<code>{{$term}}</code>{{end}}, so {{if eq (len .PrefixTerms) 1}}it was matched as a prefix{{else}}they were matched as prefixes{{end}}.</p>
+{{end}}{{if .Total}}<h3>Connections in order of {{.Order}}:</h3>
+<p>Presynaptic cells in search: {{.Pre}}<br />
+Postsynaptic cells in search: {{.Post}}<br />
+<a href="{{.Link}}">Link to these results</a></p>
+{{template "matches" .}}
+<p><strong>Total: {{.Synapses}} synapses in {{.Total}} connections{{if ne .Total .Unfiltered}}, of {{.Unfiltered}} before the strength limits{{end}}.</strong></p>
+{{if not .Connections}}<p>No connections past {{.Opts.Offset}} of {{.Total}}.</p>
+{{else}}{{if lt (len .Connections) .Total}}<p>Showing connections {{.First}} to {{.Last}} of {{.Total}}.</p>
+{{end}}{{if .Truncated}}<p><strong>Results truncated to {{len .Connections}} connections.  Refine your search, download the CSV, or page through with offset and limit to see all {{.Total}}.</strong></p>
+{{end}}<table><tr><th>Rank</th><th># Synapses</th><th>Percentile</th>{{if .Opts.Normalize}}<th>% of postsynaptic input</th>{{end}}<th>Presynaptic cell</th><th>Postsynaptic cell</th></tr>
+{{range .Connections}}<tr><td>{{$.Rank .}}</td><td>{{.Strength}}</td><td>{{printf "%.1f" ($.Percentile .)}}</td>{{if $.Opts.Normalize}}<td>{{printf "%.2f%%" ($.InputPercent .)}}</td>{{end}}<td>{{.Pre}}</td><td>{{.Post}}</td></tr>{{end}}</table>
+{{end}}{{else}}<p><strong>No connections found.</strong></p>
+{{if and .PreMatches .PostMatches}}<p>The presynaptic patterns matched {{len .PreMatches}} cells and the postsynaptic patterns matched {{len .PostMatches}} cells, but there are no connections between them{{if or .Opts.MinStrength .Opts.MaxStrength .Opts.ExcludeSelf}} within the search limits{{end}}.</p>
+{{template "matches" .}}{{end}}{{if not .PreMatches}}<p>No cell names match the presynaptic patterns: {{.Pre}}.{{if .PreSuggestions}}  Did you mean {{range $i, $name := .PreSuggestions}}{{if $i}}, {{end}}<code>{{$name}}</code>{{end}}?{{end}}</p>
+{{end}}{{if not .PostMatches}}<p>No cell names match the postsynaptic patterns: {{.Post}}.{{if .PostSuggestions}}  Did you mean {{range $i, $name := .PostSuggestions}}{{if $i}}, {{end}}<code>{{$name}}</code>{{end}}?{{end}}</p>
+{{end}}{{end}}
 		</div>
 	</div>
   </body>
 </html>
-`
+{{define "matches"}}<details><summary>{{len .PreMatches}} presynaptic cells matched</summary><p>{{range $i, $name := .PreMatches}}{{if $i}}, {{end}}{{$name}}{{end}}</p></details>
+<details><summary>{{len .PostMatches}} postsynaptic cells matched</summary><p>{{range $i, $name := .PostMatches}}{{if $i}}, {{end}}{{$name}}{{end}}</p></details>
+{{end}}`))
 
 const (
-	DefaultCellsFilename = "cell_names.csv"
+	DefaultCellsFilename        = "cell_names.csv"
 	DefaultConnectivityFilename = "connectivity_mat_379.csv"
-	DefaultWebAddress = "localhost:8000"
+	DefaultWebAddress           = "localhost:8000"
+
+	DefaultReadTimeout  = 15 * time.Second
+	DefaultWriteTimeout = 30 * time.Second
+	DefaultIdleTimeout  = 60 * time.Second
+
+	// Factor fractional strengths are multiplied by in -float mode.
+	DefaultFloatScale = 1000.0
+
+	// Default cap on connections shown in one HTML results page.
+	DefaultMaxResults = 5000
+
+	// Default limit on the size of a search request body.
+	DefaultMaxBodyBytes = 1 << 20
 
 	// The relative URL path to our API
 	WebAPIPath = "/api/"
+
+	// Connectivity file formats: a square matrix ordered as the cell names
+	// file, or one "pre,post,strength" row per connection.
+	FormatMatrix   = "matrix"
+	FormatEdgeList = "edgelist"
+
+	// How the first row of the CSV files is treated.  HeaderNames skips
+	// the row and, for a matrix, checks it lists the cell names in order.
+	HeaderNone  = "none"
+	HeaderSkip  = "skip"
+	HeaderNames = "names"
 )
 
-var (
-	connectivity NamedConnectome 
-	cellList CellList
-	cellSet map[string]bool
+// connectFlag collects the connectomes given by -connect flags, each a
+// file name or name=file.  It holds the default connectome until the
+// first flag replaces it.
+type connectFlag struct {
+	files []ConnectomeFile
+	set   bool
+}
+
+// connectomeName returns the type of a connectome given without a name,
+// which is its file name without extension.
+func connectomeName(filename string) string {
+	base := filepath.Base(filename)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
 
-	cellsFilename = flag.String("names", DefaultCellsFilename, "")
-	connectivityFilename = flag.String("connect", DefaultConnectivityFilename, "")
-	httpAddress = flag.String("http", DefaultWebAddress, "")
+func (f *connectFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	values := make([]string, len(f.files))
+	for i, file := range f.files {
+		if values[i] = file.Filename; file.Name != connectomeName(file.Filename) {
+			values[i] = file.Name + "=" + file.Filename
+		}
+	}
+	return strings.Join(values, " ")
+}
 
-	webPagesDir = filepath.Join(currentDir(), "web_pages")
+func (f *connectFlag) Set(value string) error {
+	if !f.set {
+		f.files, f.set = nil, true
+	}
+	name, filename, found := strings.Cut(value, "=")
+	if !found {
+		name, filename = connectomeName(value), value
+	}
+	if name == "" || filename == "" {
+		return fmt.Errorf("connectome %q needs both a name and a file", value)
+	}
+	for _, file := range f.files {
+		if file.Name == name {
+			return fmt.Errorf("connectome type %q is given more than once", name)
+		}
+	}
+	f.files = append(f.files, ConnectomeFile{name, filename})
+	return nil
+}
 
-	showHelp = flag.Bool("help", false, "")
-	runDebug = flag.Bool("debug", false, "")
+var connectomeFiles = connectFlag{files: []ConnectomeFile{
+	{connectomeName(DefaultConnectivityFilename), DefaultConnectivityFilename},
+}}
+
+func init() {
+	flag.Var(&connectomeFiles, "connect", "")
+}
+
+var (
+	cellsFilename         = flag.String("names", DefaultCellsFilename, "")
+	connectivityFilename2 = flag.String("connect2", "", "")
+	connectivityFormat    = flag.String("format", FormatMatrix, "")
+	csvHeader             = flag.String("header", HeaderNone, "")
+	collapseSpaces        = flag.Bool("collapse-spaces", false, "")
+	csvDelimiter          = flag.String("delimiter", ",", "")
+	floatStrengths        = flag.Bool("float", false, "")
+	floatScale            = flag.Float64("float-scale", DefaultFloatScale, "")
+	httpAddress           = flag.String("http", DefaultWebAddress, "")
+	ignoreCase            = flag.Bool("ignorecase", false, "")
+	readTimeout           = flag.Duration("read-timeout", DefaultReadTimeout, "")
+	writeTimeout          = flag.Duration("write-timeout", DefaultWriteTimeout, "")
+	idleTimeout           = flag.Duration("idle-timeout", DefaultIdleTimeout, "")
+	noCompress            = flag.Bool("no-compress", false, "")
+	searchCacheSize       = flag.Int("search-cache", DefaultSearchCacheSize, "")
+	maxResults            = flag.Int("max-results", DefaultMaxResults, "")
+	maxBodyBytes          = flag.Int64("max-body", DefaultMaxBodyBytes, "")
+	idmapFilename         = flag.String("idmap", "", "")
+	allowOrigin           = flag.String("allow-origin", "", "")
+	tlsCert               = flag.String("tls-cert", "", "")
+	tlsKey                = flag.String("tls-key", "", "")
+	reloadToken           = flag.String("reload-token", "", "")
+
+	webDir = flag.String("webdir", "", "")
+
+	showHelp    = flag.Bool("help", false, "")
+	showVersion = flag.Bool("version", false, "")
+	runDebug    = flag.Bool("debug", false, "")
+	logLevel    = flag.String("loglevel", "info", "")
 )
 
-func currentDir() string {
-	currentDir, err := os.Getwd()
+// The web pages compiled into the binary.
+//
+//go:embed web_pages
+var embeddedPages embed.FS
+
+// webPagesFS returns the web pages to serve and a description of where they
+// come from: the -webdir directory on disk if given, which is handy for
+// editing pages during development, or else the embedded pages.
+func webPagesFS() (fs.FS, string) {
+	if *webDir != "" {
+		dir, err := filepath.Abs(*webDir)
+		if err != nil {
+			fatal("could not resolve -webdir", "err", err)
+		}
+		return os.DirFS(dir), dir
+	}
+	pages, err := fs.Sub(embeddedPages, "web_pages")
 	if err != nil {
-		log.Fatalln("Could not get current directory:", err)
+		fatal("could not read embedded web pages", "err", err)
+	}
+	return pages, "embedded web_pages"
+}
+
+// Dataset holds the cell names and connectome loaded from a pair of CSV
+// files.  A Dataset is never modified once loaded; a reload swaps in a new
+// one, so holders of a *Dataset always see a consistent snapshot.
+type Dataset struct {
+	Cells        CellList
+	CellSet      map[string]bool
+	Connectivity *NamedConnectome
+
+	// Sorted index of cell names for matching search patterns.
+	Names *NameIndex
+
+	// Total synapses onto each cell, precomputed at load.
+	InSynapses map[string]int
+
+	// Summary statistics, precomputed at load.
+	Stats Stats
+
+	// Strength of every nonzero connection in ascending order, for
+	// percentiles.
+	strengths []int
+
+	// Cell names by body id, if an id map was loaded.
+	BodyNames map[string]string
+
+	// Name of the connectome type, as chosen by the "type" parameter.
+	Type string
+
+	// Results of recent searches, or nil if not caching.  A reload
+	// replaces the Dataset and so starts with an empty cache.
+	searches *SearchCache
+}
+
+// NewDataset returns a Dataset for the given cells and connectome with
+// all per-cell indices computed.
+func NewDataset(cells CellList, connects *NamedConnectome) *Dataset {
+	set := cells.NameSet()
+	d := &Dataset{
+		Cells:        cells,
+		CellSet:      set,
+		Connectivity: connects,
+		Names:        NewNameIndex(set),
+		InSynapses:   connects.InDegrees(),
+	}
+	d.Stats = d.computeStats()
+	d.strengths = make([]int, 0, d.Stats.Connections)
+	connects.EachConnection(func(pre, post string, strength int) {
+		d.strengths = append(d.strengths, strength)
+	})
+	sort.Ints(d.strengths)
+	return d
+}
+
+// Percentile returns the percentage of all nonzero connections in the
+// dataset that are no stronger than the given strength.
+func (d *Dataset) Percentile(strength int) float64 {
+	if len(d.strengths) == 0 {
+		return 0
+	}
+	atMost := sort.SearchInts(d.strengths, strength+1)
+	return 100 * float64(atMost) / float64(len(d.strengths))
+}
+
+// InputPercent returns the connection's strength as a percentage of the
+// total synapses onto its postsynaptic cell.
+func (d *Dataset) InputPercent(connection Connection) float64 {
+	total := d.InSynapses[connection.post]
+	if total == 0 {
+		return 0
 	}
-	return currentDir
+	return 100 * float64(connection.strength) / float64(total)
+}
+
+// CSVOptions control how the CSV files are parsed.
+type CSVOptions struct {
+	// One of HeaderNone, HeaderSkip or HeaderNames.
+	Header string
+
+	// Field separator, or a comma if zero.
+	Comma rune
+
+	// If nonzero, strengths may be fractional and are multiplied by Scale
+	// and rounded to the nearest integer.  Otherwise they must be integers.
+	Scale float64
+
+	// If set, runs of whitespace within cell names become a single space.
+	CollapseSpaces bool
+}
+
+// normalizeName returns a cell name without leading or trailing whitespace,
+// including unicode spaces such as non-breaking ones, and with internal
+// runs of whitespace collapsed if the options say so.
+func (opts CSVOptions) normalizeName(name string) string {
+	if opts.CollapseSpaces {
+		return strings.Join(strings.Fields(name), " ")
+	}
+	return strings.TrimFunc(name, unicode.IsSpace)
+}
+
+// parseStrength returns the connection strength written as s, and whether
+// it is a positive fractional weight lost by rounding to 0.
+func (opts CSVOptions) parseStrength(s string) (strength int, lost bool, err error) {
+	if opts.Scale == 0 {
+		strength, err = strconv.Atoi(s)
+		return
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false, fmt.Errorf("%q is not a finite number", s)
+	}
+	scaled := math.Round(value * opts.Scale)
+	if scaled < math.MinInt || scaled >= math.MaxInt+1 {
+		return 0, false, fmt.Errorf("%q scaled by %g is out of range", s, opts.Scale)
+	}
+	strength = int(scaled)
+	return strength, strength == 0 && value > 0, nil
+}
+
+// warnLost warns of the number of positive weights in a file dropped by
+// parseStrength rounding them to 0, if any.
+func (opts CSVOptions) warnLost(filename string, lost int) {
+	if lost > 0 {
+		slog.Warn("dropped fractional strengths that round to 0; raise -float-scale to keep them",
+			"file", filename, "count", lost, "scale", opts.Scale)
+	}
+}
+
+// ParseDelimiter returns the field separator named by s, which must be a
+// single character or \t for a tab.
+func ParseDelimiter(s string) (rune, error) {
+	if s == `\t` {
+		return '\t', nil
+	}
+	comma, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) || comma == utf8.RuneError {
+		return 0, fmt.Errorf("delimiter %q must be a single character or \\t", s)
+	}
+	if comma == '"' || comma == '\r' || comma == '\n' {
+		return 0, fmt.Errorf("delimiter %q cannot be a quote or line break", s)
+	}
+	return comma, nil
+}
+
+// newReader returns a CSV reader of the file using the options' delimiter.
+func (opts CSVOptions) newReader(file io.Reader) *csv.Reader {
+	csvReader := csv.NewReader(file)
+	if opts.Comma != 0 {
+		csvReader.Comma = opts.Comma
+	}
+	return csvReader
+}
+
+// readHeader reads and returns the first row of a CSV file if the options
+// say it is a header, or returns nil otherwise.
+func (opts CSVOptions) readHeader(csvReader *csv.Reader, filename string) ([]string, error) {
+	switch opts.Header {
+	case "", HeaderNone:
+		return nil, nil
+	case HeaderSkip, HeaderNames:
+		header, err := csvReader.Read()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error on reading header of %s: %w", filename, err)
+		}
+		return header, nil
+	}
+	return nil, fmt.Errorf("unknown header option %q", opts.Header)
+}
+
+// LoadDataset reads the cell names and connectivity CSV files.  The format
+// is either FormatMatrix or FormatEdgeList.  For edge lists, the cell names
+// file is optional: if cellsFilename is empty, the names are discovered from
+// the edges, and otherwise every edge must use names from the file.
+func LoadDataset(cellsFilename, connectivityFilename, format string, opts CSVOptions) (*Dataset, error) {
+	switch format {
+	case FormatMatrix:
+		cells, err := ReadCellsCSV(cellsFilename, opts)
+		if err != nil {
+			return nil, err
+		}
+		connects, err := ReadConnectionsCSV(cells, connectivityFilename, opts)
+		if err != nil {
+			return nil, err
+		}
+		return NewDataset(cells, connects), nil
+	case FormatEdgeList:
+		connects, err := ReadEdgeListCSV(connectivityFilename, opts)
+		if err != nil {
+			return nil, err
+		}
+		if cellsFilename == "" {
+			return NewDataset(connects.CellNames(), connects), nil
+		}
+		cells, err := ReadCellsCSV(cellsFilename, opts)
+		if err != nil {
+			return nil, err
+		}
+		set := cells.NameSet()
+		for _, name := range connects.CellNames() {
+			if !set[name] {
+				return nil, fmt.Errorf("cell %q in %s is not in names file %s",
+					name, connectivityFilename, cellsFilename)
+			}
+		}
+		return NewDataset(cells, connects), nil
+	}
+	return nil, fmt.Errorf("unknown connectivity format %q", format)
 }
 
 // Slice of cell names whose order is important since it matches the
-// connectivity matrix.
+// connectivity matrix.  It intentionally does not implement sort.Interface
+// so it can't be reordered in place; use Sorted() for an alphabetical copy.
 type CellList []string
 
-func (list CellList) Len() int           { return len(list) }
-func (list CellList) Swap(i, j int)      { list[i], list[j] = list[j], list[i] }
-func (list CellList) Less(i, j int) bool { return list[i] > list[j] }
+// Sorted returns a copy of the cell names in ascending alphabetical order,
+// leaving the matrix ordering of the original list untouched.
+func (list CellList) Sorted() CellsByName {
+	sorted := make(CellsByName, len(list))
+	copy(sorted, list)
+	sort.Sort(sorted)
+	return sorted
+}
+
+// NameSet returns the set of cell names for fast membership tests.
+func (list CellList) NameSet() map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, name := range list {
+		set[name] = true
+	}
+	return set
+}
+
+// CellsByName is a slice of cell names sorted in ascending order.
+type CellsByName []string
+
+func (list CellsByName) Len() int           { return len(list) }
+func (list CellsByName) Swap(i, j int)      { list[i], list[j] = list[j], list[i] }
+func (list CellsByName) Less(i, j int) bool { return list[i] < list[j] }
 
 type Connection struct {
 	pre      string
@@ -129,155 +585,898 @@ type Connection struct {
 	strength int
 }
 
+func (c Connection) Pre() string   { return c.pre }
+func (c Connection) Post() string  { return c.post }
+func (c Connection) Strength() int { return c.strength }
+
 type ConnectionList []Connection
 
-func (list ConnectionList) Len() int           { return len(list) }
-func (list ConnectionList) Swap(i, j int)      { list[i], list[j] = list[j], list[i] }
-func (list ConnectionList) Less(i, j int) bool { return list[i].strength > list[j].strength }
+func (list ConnectionList) Len() int      { return len(list) }
+func (list ConnectionList) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
+
+// Less orders connections by descending strength, breaking ties by pre and
+// then post name so the order is deterministic.
+func (list ConnectionList) Less(i, j int) bool {
+	a, b := list[i], list[j]
+	if a.strength != b.strength {
+		return a.strength > b.strength
+	}
+	if a.pre != b.pre {
+		return a.pre < b.pre
+	}
+	return a.post < b.post
+}
 
 func (list ConnectionList) SortByStrength() {
 	sort.Sort(list)
 }
 
-// NamedConnectome holds strength of connections between two bodies
-// that are identified using names (strings) instead of body ids as
-// in the Connectome type.
-type NamedConnectome map[string](map[string]int)
-
-// GetConnection returns a (pre, post) strength and 'found' bool.
-func (nc NamedConnectome) ConnectionStrength(pre, post string) (strength int, found bool) {
-	connections, found := nc[pre]
-	if found {
-		_, found = connections[post]
-		if found {
-			strength = nc[pre][post]
-			if strength == 0 {
-				found = false
-			}
-		}
+// TotalStrength returns the sum of strengths over all connections.
+func (list ConnectionList) TotalStrength() (total int) {
+	for _, connection := range list {
+		total += connection.strength
 	}
 	return
 }
 
-// AddConnection adds a (pre, post) connection of given strength
-// to a connectome.
-func (nc *NamedConnectome) AddConnection(pre, post string, strength int) {
-	if len(*nc) == 0 {
-		*nc = make(NamedConnectome)
+// Page returns the connections after skipping offset of them, limited to
+// at most limit connections unless limit is 0.
+func (list ConnectionList) Page(offset, limit int) ConnectionList {
+	if offset >= len(list) {
+		return list[:0]
+	}
+	list = list[offset:]
+	if limit > 0 && limit < len(list) {
+		list = list[:limit]
 	}
-	connections, preFound := (*nc)[pre]
-	if preFound {
-		_, postFound := connections[post]
-		if postFound {
-			(*nc)[pre][post] += strength
-		} else {
-			(*nc)[pre][post] = strength
+	return list
+}
+
+// ConnectionsByName sorts connections by presynaptic then postsynaptic name.
+type ConnectionsByName struct{ ConnectionList }
+
+func (list ConnectionsByName) Less(i, j int) bool {
+	a, b := list.ConnectionList[i], list.ConnectionList[j]
+	if a.pre != b.pre {
+		return a.pre < b.pre
+	}
+	return a.post < b.post
+}
+
+func (list ConnectionList) SortByName() {
+	sort.Sort(ConnectionsByName{list})
+}
+
+// ConnectionsByPost sorts connections by postsynaptic then presynaptic name.
+type ConnectionsByPost struct{ ConnectionList }
+
+func (list ConnectionsByPost) Less(i, j int) bool {
+	a, b := list.ConnectionList[i], list.ConnectionList[j]
+	if a.post != b.post {
+		return a.post < b.post
+	}
+	return a.pre < b.pre
+}
+
+// ConnectionsByWeakest sorts connections by ascending strength, breaking
+// ties by pre and then post name.
+type ConnectionsByWeakest struct{ ConnectionList }
+
+func (list ConnectionsByWeakest) Less(i, j int) bool {
+	a, b := list.ConnectionList[i], list.ConnectionList[j]
+	if a.strength != b.strength {
+		return a.strength < b.strength
+	}
+	if a.pre != b.pre {
+		return a.pre < b.pre
+	}
+	return a.post < b.post
+}
+
+// Orders of search results chosen by the "sort" parameter.
+const (
+	SortStrengthDesc = "strength_desc"
+	SortStrengthAsc  = "strength_asc"
+	SortPre          = "pre"
+	SortPost         = "post"
+)
+
+// InRange returns the connections within the options' strength window,
+// reusing the list's storage.
+func (list ConnectionList) InRange(opts SearchOptions) ConnectionList {
+	kept := list[:0]
+	for _, connection := range list {
+		if opts.inRange(connection.strength) {
+			kept = append(kept, connection)
 		}
-	} else {
-		(*nc)[pre] = make(map[string]int)
-		(*nc)[pre][post] = strength
+	}
+	return kept
+}
+
+// StrengthRanks holds the strengths of a result set's connections from
+// strongest to weakest, for ranking them however the results are sorted.
+type StrengthRanks []int
+
+// StrengthRanks returns the ranks of the list's connections by strength.
+func (list ConnectionList) StrengthRanks() StrengthRanks {
+	strengths := make([]int, len(list))
+	for i, connection := range list {
+		strengths[i] = connection.strength
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(strengths)))
+	return strengths
+}
+
+// Rank returns the competition rank of a strength: one more than the
+// number of stronger connections.  Equally strong connections share a
+// rank and the ranks after them are skipped, as in 1, 2, 2, 4.
+func (ranks StrengthRanks) Rank(strength int) int {
+	return 1 + sort.Search(len(ranks), func(i int) bool { return ranks[i] <= strength })
+}
+
+// SortBy sorts connections in one of the Sort orders, strongest first if
+// order is empty.
+func (list ConnectionList) SortBy(order string) {
+	switch order {
+	case SortStrengthAsc:
+		sort.Sort(ConnectionsByWeakest{list})
+	case SortPre:
+		list.SortByName()
+	case SortPost:
+		sort.Sort(ConnectionsByPost{list})
+	default:
+		list.SortByStrength()
 	}
 }
 
-// MatchingNames returns a slice of body names that have prefixes matching
-// the given slice of patterns
+// MatchingNames returns a slice of body names that match the given slice
+// of patterns.  An asterisk (*) acts as a wild-card only at the start and/or
+// end of a pattern, and patterns are interpreted as follows:
+//
+//	"*"       matches every name
+//	"*Mi1*"   substring: names containing "Mi1"
+//	"*LC10"   suffix: names ending with "LC10"
+//	"L1*"     prefix: names starting with "L1"
+//	"L1 209"  exact: only the name "L1 209"
+//
+// A pattern starting with a minus sign (-) or exclamation mark (!) removes
+// the names it matches from those matched by the other patterns, so
+// "Mi*", "-Mi1*" matches every Mi cell except the Mi1 cells.  Exclusions
+// alone match nothing.  Empty or whitespace-only patterns are skipped, and
+// a name matched by overlapping patterns is returned once, where first
+// matched.
 func MatchingNames(names map[string]bool, patterns []string) (matches []string) {
+	return MatchingNamesCase(names, patterns, false)
+}
+
+// MatchingNamesCase is like MatchingNames but optionally ignores case when
+// comparing patterns to names, including for exact matches.
+func MatchingNamesCase(names map[string]bool, patterns []string, ignoreCase bool) (matches []string) {
+	return matchNames(names, nil, patterns, ignoreCase)
+}
+
+// matchNames implements MatchingNamesCase, using the sorted index for
+// prefix patterns if one is given.
+func matchNames(names map[string]bool, index *NameIndex, patterns []string, ignoreCase bool) (matches []string) {
 	matches = make([]string, 0, len(patterns))
+	var exclusions []string
 	for _, pattern := range patterns {
-	    if len(pattern) == 0 {
-	        pattern = "*"
-	    }
-		if pattern[len(pattern)-1:] == "*" {
-			// Use as prefix
-			pattern = pattern[:len(pattern)-1]
+		// Blank patterns contribute no matches.
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) == 0 {
+			continue
+		}
+		if isExclusion(pattern) {
+			exclusions = append(exclusions, pattern[1:])
+			continue
+		}
+		leading := strings.HasPrefix(pattern, "*")
+		trailing := len(pattern) > 1 && strings.HasSuffix(pattern, "*")
+		if !leading && !trailing {
+			// Require exact matching
+			if !ignoreCase {
+				_, found := names[pattern]
+				if found {
+					matches = append(matches, pattern)
+				}
+				continue
+			}
 			for name, _ := range names {
-				if strings.HasPrefix(name, pattern) {
+				if strings.EqualFold(name, pattern) {
 					matches = append(matches, name)
 				}
 			}
-		} else {
-			// Require exact matching
-			_, found := names[pattern]
-			if found {
-				matches = append(matches, pattern)
+			continue
+		}
+		if ignoreCase {
+			pattern = strings.ToLower(pattern)
+		}
+		var match func(name, s string) bool
+		switch {
+		case leading && trailing:
+			match = strings.Contains
+			pattern = pattern[1 : len(pattern)-1]
+		case leading:
+			match = strings.HasSuffix
+			pattern = pattern[1:]
+		default:
+			match = strings.HasPrefix
+			pattern = pattern[:len(pattern)-1]
+			if index != nil {
+				matches = append(matches, index.WithPrefix(pattern, ignoreCase)...)
+				continue
+			}
+		}
+		for name, _ := range names {
+			candidate := name
+			if ignoreCase {
+				candidate = strings.ToLower(name)
+			}
+			if match(candidate, pattern) {
+				matches = append(matches, name)
 			}
 		}
 	}
+	// Drop repeats and excluded names, keeping the first match of each.
+	skip := make(map[string]bool, len(matches))
+	if len(exclusions) > 0 && len(matches) > 0 {
+		for _, name := range matchNames(names, index, exclusions, ignoreCase) {
+			skip[name] = true
+		}
+	}
+	kept := matches[:0]
+	for _, name := range matches {
+		if !skip[name] {
+			skip[name] = true
+			kept = append(kept, name)
+		}
+	}
+	matches = kept
 	return
 }
 
-// Handler for all web page requests except for API
-func mainHandler(w http.ResponseWriter, r *http.Request) {
-	path := "index.html"
-	if r.URL.Path != "/" {
-		path = r.URL.Path
+// isExclusion returns true if a pattern removes names from a match.
+func isExclusion(pattern string) bool {
+	return strings.HasPrefix(pattern, "-") || strings.HasPrefix(pattern, "!")
+}
+
+// NameIndex holds cell names in sorted order, both as given and lowercased,
+// so prefix matches need only a binary search and a scan of the matches.
+type NameIndex struct {
+	set    map[string]bool
+	sorted []string
+	folded []foldedName
+}
+
+type foldedName struct {
+	lower string
+	name  string
+}
+
+// NewNameIndex returns an index of the given set of names.
+func NewNameIndex(names map[string]bool) *NameIndex {
+	index := &NameIndex{
+		set:    names,
+		sorted: make([]string, 0, len(names)),
+		folded: make([]foldedName, 0, len(names)),
+	}
+	for name := range names {
+		index.sorted = append(index.sorted, name)
+		index.folded = append(index.folded, foldedName{strings.ToLower(name), name})
 	}
-	filename := filepath.Join(webPagesDir, path)
-	log.Printf("Serving %s -> %s\n", r.URL.Path, filename)
-	http.ServeFile(w, r, filename)
+	sort.Strings(index.sorted)
+	sort.Slice(index.folded, func(i, j int) bool {
+		return index.folded[i].lower < index.folded[j].lower
+	})
+	return index
 }
 
-// Handler for all search requests, i.e., POST of two cell search patterns.
-func searchHandler(w http.ResponseWriter, r *http.Request) {
-	action := strings.ToLower(r.Method)
-	if action == "post" {
-		preNames := r.FormValue("pre")
-		postNames := r.FormValue("post")
-		results := getSearchHTML(preNames, postNames)
-		fmt.Fprintf(w, htmlTemplate, results)
-	} else {
-		http.Error(w, "Illegal search request.  Requires POST.", http.StatusBadRequest)
+// WithPrefix returns the names starting with prefix in ascending order.  If
+// ignoreCase is set, prefix must already be lowercase.
+func (index *NameIndex) WithPrefix(prefix string, ignoreCase bool) []string {
+	if ignoreCase {
+		i := sort.Search(len(index.folded), func(i int) bool { return index.folded[i].lower >= prefix })
+		var names []string
+		for ; i < len(index.folded) && strings.HasPrefix(index.folded[i].lower, prefix); i++ {
+			names = append(names, index.folded[i].name)
+		}
+		return names
+	}
+	i := sort.SearchStrings(index.sorted, prefix)
+	j := i
+	for j < len(index.sorted) && strings.HasPrefix(index.sorted[j], prefix) {
+		j++
+	}
+	return index.sorted[i:j]
+}
+
+// Matching is like MatchingNamesCase for the indexed names.
+func (index *NameIndex) Matching(patterns []string, ignoreCase bool) []string {
+	return matchNames(index.set, index, patterns, ignoreCase)
+}
+
+// Longest regular expression accepted in a regex search.  Go's regexp
+// package always runs in linear time, so this only bounds the cost of
+// compiling and matching one expression against every name.
+const MaxRegexpLength = 256
+
+// compileRegexp compiles a user-supplied regular expression, refusing
+// any longer than MaxRegexpLength.
+func compileRegexp(expr string, ignoreCase bool) (*regexp.Regexp, error) {
+	if len(expr) > MaxRegexpLength {
+		return nil, fmt.Errorf("regular expression is longer than %d characters", MaxRegexpLength)
+	}
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+	return re, nil
+}
+
+// MatchingRegexp returns the names, in ascending order, containing a match
+// of any of the regular expressions, which may use ^ and $ to anchor them.
+// The expressions are separated as by splitRegexps and each is compiled
+// on its own; an invalid one is an error quoting it.  Blank expressions
+// match nothing.
+func (index *NameIndex) MatchingRegexp(exprs string, ignoreCase bool) ([]string, error) {
+	var res []*regexp.Regexp
+	for _, expr := range splitRegexps(exprs) {
+		re, err := compileRegexp(expr, ignoreCase)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", expr, err)
+		}
+		res = append(res, re)
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	var names []string
+	for _, name := range index.sorted {
+		for _, re := range res {
+			if re.MatchString(name) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+// webFilename returns the name within the served pages for a URL path, or
+// false if the path tries to leave them through ".." segments, backslashes
+// or an absolute path.
+func webFilename(urlPath string) (string, bool) {
+	if strings.ContainsAny(urlPath, "\\\x00") {
+		return "", false
+	}
+	for _, segment := range strings.Split(urlPath, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		name = "index.html"
+	}
+	return name, fs.ValidPath(name)
+}
+
+// Handler for all web page requests except for API.  Unknown paths and
+// directories without an index.html are answered with the NotFoundPage
+// and a 404 status rather than a listing or a filesystem error, and paths
+// that would escape the served pages are rejected.
+func (s *Server) mainHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := webFilename(r.URL.Path)
+	if !ok {
+		slog.Warn("rejected web path", "id", requestID(r), "path", r.URL.Path)
+		http.Error(w, "Illegal path.", http.StatusBadRequest)
+		return
+	}
+	info, err := fs.Stat(s.config.WebPages, name)
+	if err == nil && info.IsDir() {
+		name = path.Join(name, "index.html")
+		info, err = fs.Stat(s.config.WebPages, name)
+	}
+	if err != nil || info.IsDir() {
+		s.notFoundHandler(w, r)
+		return
+	}
+	file, err := s.config.WebPages.Open(name)
+	if err != nil {
+		s.notFoundHandler(w, r)
+		return
+	}
+	defer file.Close()
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		http.Error(w, "Page cannot be served.", http.StatusInternalServerError)
+		return
+	}
+	logAttrs(r, "file", name)
+	logAtDebug(r)
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// Web page, within the served pages, served for unknown paths.
+const NotFoundPage = "404.html"
+
+// Handler for unknown web paths, falling back to a plain text 404 if the
+// NotFoundPage itself is missing.
+func (s *Server) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := fs.ReadFile(s.config.WebPages, NotFoundPage)
+	if err != nil {
+		http.NotFound(w, r)
+		return
 	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write(page)
 }
 
-func getSearchHTML(preNames, postNames string) (text string) {
-	pre := strings.Split(preNames, ",")
-	post := strings.Split(postNames, ",")
-	for i, _ := range pre {
-		pre[i] = strings.TrimSpace(pre[i])
+// Handler for all search requests, i.e., POST of two cell search patterns
+// or GET of the same fields as query parameters, which makes results
+// shareable as links like search?pre=L1*&post=Mi1*.  A "format" value of
+// "csv" returns the results as a CSV attachment instead of an HTML page.
+// See searchOptions for other parameters.
+func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	if status, err := s.parseForm(w, r); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	opts, err := s.searchOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("format") == "csv" {
+		d, err := s.requestData(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		connections, unfiltered, err := s.search(r, d, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		connections.SortBy(opts.Sort)
+		w.Header().Set("X-Total-Count", strconv.Itoa(len(connections)))
+		w.Header().Set("X-Unfiltered-Count", strconv.Itoa(unfiltered))
+		writeConnectionsCSV(w, "search.csv", connections.Page(opts.Offset, opts.Limit))
+		return
 	}
-	for i, _ := range post {
-		post[i] = strings.TrimSpace(post[i])
+	html, err := s.getSearchHTML(r, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	connections := make(ConnectionList, 0, len(pre))
-	for _, preName := range MatchingNames(cellSet, pre) {
-		for _, postName := range MatchingNames(cellSet, post) {
-			strength, found := connectivity.ConnectionStrength(preName, postName)
-			if found {
+	fmt.Fprint(w, html)
+}
+
+// SearchOptions modify how search patterns are matched to cell names and
+// how the resulting connections are presented.
+type SearchOptions struct {
+	// Match names regardless of case.
+	IgnoreCase bool
+
+	// Drop self-connections where pre and post are the same cell.
+	ExcludeSelf bool
+
+	// Keep only connections with strength of at least MinStrength and, if
+	// MaxStrength is nonzero, at most MaxStrength.
+	MinStrength, MaxStrength int
+
+	// Show each connection's percentage of postsynaptic input.
+	Normalize bool
+
+	// After sorting, skip Offset connections and show at most Limit of
+	// the rest, or all of them if Limit is 0.
+	Offset, Limit int
+
+	// Treat each of the pre and post fields as one regular expression
+	// rather than comma-separated wildcard patterns.
+	Regex bool
+
+	// Retry exact patterns that match no name as prefixes.
+	Fuzzy bool
+
+	// Order of the results, one of the Sort constants.
+	Sort string
+}
+
+// inRange returns true if strength is within the options' strength window.
+func (opts SearchOptions) inRange(strength int) bool {
+	return strength >= opts.MinStrength && (opts.MaxStrength == 0 || strength <= opts.MaxStrength)
+}
+
+// SearchConnections returns all connections between cells matching the
+// comma-separated pre and post patterns.  The returned list is unsorted.
+func (d *Dataset) SearchConnections(preNames, postNames string, opts SearchOptions) (ConnectionList, error) {
+	preMatches, postMatches, err := d.SearchMatches(preNames, postNames, opts)
+	if err != nil {
+		return nil, err
+	}
+	return d.ConnectionsBetween(preMatches, postMatches, opts), nil
+}
+
+// SearchMatches returns the cell names matching the comma-separated pre
+// and post patterns, which may also be separated by semicolons or line
+// breaks.  Patterns of the form "id:<body id>" are replaced by
+// the mapped cell name, and an unmapped id is an error.  With opts.Fuzzy,
+// exact patterns matching no name are tried as prefixes.  With opts.Regex,
+// pre and post are instead lists of regular expressions, and an invalid one
+// is an error.
+func (d *Dataset) SearchMatches(preNames, postNames string, opts SearchOptions) (preMatches, postMatches []string, err error) {
+	if opts.Regex {
+		if preMatches, err = d.Names.MatchingRegexp(preNames, opts.IgnoreCase); err != nil {
+			return nil, nil, fmt.Errorf("pre: %w", err)
+		}
+		if postMatches, err = d.Names.MatchingRegexp(postNames, opts.IgnoreCase); err != nil {
+			return nil, nil, fmt.Errorf("post: %w", err)
+		}
+		return
+	}
+	pre := splitPatterns(preNames)
+	post := splitPatterns(postNames)
+	if err = d.resolveBodyIDs(pre); err != nil {
+		return
+	}
+	if err = d.resolveBodyIDs(post); err != nil {
+		return
+	}
+	if opts.Fuzzy {
+		for _, i := range d.unmatchedExact(pre, opts.IgnoreCase) {
+			pre[i] += "*"
+		}
+		for _, i := range d.unmatchedExact(post, opts.IgnoreCase) {
+			post[i] += "*"
+		}
+	}
+	preMatches = d.Names.Matching(pre, opts.IgnoreCase)
+	postMatches = d.Names.Matching(post, opts.IgnoreCase)
+	return
+}
+
+// splitPatterns splits a list of search patterns separated by commas,
+// semicolons or line breaks, so pasted lists work whatever their separator,
+// trimming each pattern and dropping empty ones.
+func splitPatterns(patterns string) []string {
+	var split []string
+	for _, pattern := range strings.FieldsFunc(patterns, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n' || r == '\r'
+	}) {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			split = append(split, pattern)
+		}
+	}
+	return split
+}
+
+// splitRegexps splits a list of regular expressions on line breaks only,
+// since commas and semicolons may be part of an expression, as in a {1,2}
+// repeat.  Each expression is trimmed and empty ones are dropped.
+func splitRegexps(exprs string) []string {
+	var split []string
+	for _, expr := range strings.FieldsFunc(exprs, func(r rune) bool {
+		return r == '\n' || r == '\r'
+	}) {
+		if expr = strings.TrimSpace(expr); expr != "" {
+			split = append(split, expr)
+		}
+	}
+	return split
+}
+
+// unmatchedExact returns the indexes of the patterns without wildcards that
+// match no name, which a fuzzy search tries as prefixes instead.  Body id
+// and exclusion patterns are never retried.
+func (d *Dataset) unmatchedExact(patterns []string, ignoreCase bool) (unmatched []int) {
+	for i, pattern := range patterns {
+		if pattern == "" || isExclusion(pattern) || strings.HasPrefix(pattern, BodyIDPrefix) ||
+			strings.HasPrefix(pattern, "*") || strings.HasSuffix(pattern, "*") {
+			continue
+		}
+		if len(d.Names.Matching([]string{pattern}, ignoreCase)) == 0 {
+			unmatched = append(unmatched, i)
+		}
+	}
+	return
+}
+
+// PrefixTerms returns the comma-separated patterns that a fuzzy search
+// matches as prefixes because no name matches them exactly.
+func (d *Dataset) PrefixTerms(patterns string, opts SearchOptions) []string {
+	if !opts.Fuzzy || opts.Regex {
+		return nil
+	}
+	terms := splitPatterns(patterns)
+	var prefixed []string
+	for _, i := range d.unmatchedExact(terms, opts.IgnoreCase) {
+		prefixed = append(prefixed, terms[i])
+	}
+	return prefixed
+}
+
+// BodyIDPrefix marks a search pattern as a body id rather than a name.
+const BodyIDPrefix = "id:"
+
+// resolveBodyIDs replaces any "id:<body id>" patterns in place with the
+// exact cell name mapped to that id, keeping any exclusion prefix.
+func (d *Dataset) resolveBodyIDs(patterns []string) error {
+	for i, pattern := range patterns {
+		var exclude string
+		if isExclusion(pattern) {
+			exclude, pattern = pattern[:1], strings.TrimSpace(pattern[1:])
+		}
+		if !strings.HasPrefix(pattern, BodyIDPrefix) {
+			continue
+		}
+		id := strings.TrimSpace(pattern[len(BodyIDPrefix):])
+		name, found := d.BodyNames[id]
+		if !found {
+			if d.BodyNames == nil {
+				return fmt.Errorf("cannot search by body id %q: no id map was loaded", id)
+			}
+			return fmt.Errorf("body id %q is not in the id map", id)
+		}
+		patterns[i] = exclude + name
+	}
+	return nil
+}
+
+// ConnectionsBetween returns all connections from the pre cells to the post
+// cells allowed by the options.  The returned list is unsorted.
+func (d *Dataset) ConnectionsBetween(preMatches, postMatches []string, opts SearchOptions) (connections ConnectionList) {
+	connections = make(ConnectionList, 0, len(preMatches))
+	for _, preName := range preMatches {
+		for _, postName := range postMatches {
+			if opts.ExcludeSelf && preName == postName {
+				continue
+			}
+			strength, found := d.Connectivity.ConnectionStrength(preName, postName)
+			if found && opts.inRange(strength) {
 				connection := Connection{preName, postName, strength}
 				connections = append(connections, connection)
 			}
 		}
 	}
-	if len(connections) > 0 {
-		connections.SortByStrength()
-		text = "<h3>Connections in order of strength:</h3>\n"
-		text += "<p>Presynaptic cells in search: " + preNames + "<br />\n"
-		text += "Postsynaptic cells in search: " + postNames + "</p>\n"
-		text += "<table><tr><th># Synapses</th><th>Presynaptic cell</th><th>Postsynaptic cell</th></tr>\n"
-		for _, connection := range connections {
-			text += fmt.Sprintf("<tr><td>%d</td><td>%s</td><td>%s</td></tr>", 
-				connection.strength, connection.pre, connection.post)
-		}
-		text += "</table>\n"
-	} else {
-		text = "<p><strong>No connections found.</strong></p>"
+	return
+}
+
+// search returns the connections matching the request's "pre" and "post"
+// patterns and their number before the strength window, noting the number
+// of matched names and connections in the request log.  The matched names
+// themselves are logged at debug level.  Results come from the dataset's
+// search cache when possible.
+func (s *Server) search(r *http.Request, d *Dataset, opts SearchOptions) (ConnectionList, int, error) {
+	_, _, connections, unfiltered, err := s.searchWithMatches(r, d, opts)
+	return connections, unfiltered, err
+}
+
+// sortedNames returns an alphabetical copy of names.
+func sortedNames(names []string) []string {
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// searchWithMatches is like search but also returns the matched names
+// and the number of connections between them before the strength window
+// was applied.
+func (s *Server) searchWithMatches(r *http.Request, d *Dataset, opts SearchOptions) (preMatches, postMatches []string, connections ConnectionList, unfiltered int, err error) {
+	result, cached, err := s.cachedSearch(d, r.FormValue("pre"), r.FormValue("post"), opts)
+	if err != nil {
+		return
+	}
+	preMatches, postMatches = result.preMatches, result.postMatches
+	unfiltered = len(result.connections)
+	connections = result.connections.InRange(opts)
+	logAttrs(r, "pre_matches", len(preMatches), "post_matches", len(postMatches),
+		"unfiltered", unfiltered, "results", len(connections), "cached", cached)
+	slog.Debug("search matches", "id", requestID(r), "pre", preMatches, "post", postMatches)
+	return
+}
+
+// cachedSearch returns the names matched by the pre and post patterns and
+// every connection between them, from the dataset's search cache when
+// possible.  The strength window is left for the caller to apply, so
+// searches that differ only in their window share a cache entry.
+func (s *Server) cachedSearch(d *Dataset, pre, post string, opts SearchOptions) (result searchResult, cached bool, err error) {
+	key := searchKey(pre, post, opts)
+	result, cached = d.searches.get(key)
+	if d.searches != nil {
+		s.metrics.observeSearchCache(cached)
+	}
+	if cached {
+		return
+	}
+	preMatches, postMatches, err := d.SearchMatches(pre, post, opts)
+	if err != nil {
+		return
 	}
+	unbounded := opts
+	unbounded.MinStrength, unbounded.MaxStrength = 0, 0
+	result = searchResult{preMatches, postMatches, d.ConnectionsBetween(preMatches, postMatches, unbounded)}
+	d.searches.add(key, result)
 	return
 }
 
-func ReadCellsCSV(filename string) (names CellList) {
+// Maximum number of names suggested for search patterns matching nothing.
+const MaxNearMisses = 5
+
+// NearMisses suggests cell names close to comma-separated patterns that
+// matched no names.  Each pattern, without wildcards, is shortened until it
+// is a prefix of some names regardless of case.
+func (d *Dataset) NearMisses(patterns string) []string {
+	var suggestions []string
+	seen := make(map[string]bool)
+	for _, pattern := range splitPatterns(patterns) {
+		pattern = strings.Trim(pattern, " *")
+		if strings.HasPrefix(pattern, BodyIDPrefix) || isExclusion(pattern) {
+			continue
+		}
+		for n := len(pattern); n > 0; n-- {
+			names := d.NameSuggestions(pattern[:n], true)
+			if len(names) == 0 {
+				continue
+			}
+			for _, name := range names {
+				if len(suggestions) < MaxNearMisses && !seen[name] {
+					seen[name] = true
+					suggestions = append(suggestions, name)
+				}
+			}
+			break
+		}
+	}
+	return suggestions
+}
+
+// SearchPage holds the data rendered by searchTemplate.
+type SearchPage struct {
+	Pre, Post   string
+	Link        string
+	Opts        SearchOptions
+	Total       int
+	Unfiltered  int
+	Synapses    int
+	Connections ConnectionList
+
+	// Set if Connections was cut short by the -max-results cap.
+	Truncated bool
+
+	// Exact patterns that a fuzzy search matched as prefixes.
+	PrefixTerms []string
+
+	// Names matched by each side's patterns, sorted, and, for a side
+	// matching nothing, suggested names.
+	PreMatches, PostMatches         []string
+	PreSuggestions, PostSuggestions []string
+
+	data  *Dataset
+	ranks StrengthRanks
+}
+
+// Order describes the order of the connections shown.
+func (page SearchPage) Order() string {
+	switch page.Opts.Sort {
+	case SortStrengthAsc:
+		return "increasing strength"
+	case SortPre:
+		return "presynaptic cell"
+	case SortPost:
+		return "postsynaptic cell"
+	}
+	return "strength"
+}
+
+// Rank returns the connection's rank by strength among all the results.
+func (page SearchPage) Rank(connection Connection) int {
+	return page.ranks.Rank(connection.strength)
+}
+
+// First returns the 1-based position of the first connection shown.
+func (page SearchPage) First() int { return page.Opts.Offset + 1 }
+
+// Last returns the 1-based position of the last connection shown.
+func (page SearchPage) Last() int { return page.Opts.Offset + len(page.Connections) }
+
+// InputPercent returns the connection's percentage of postsynaptic input.
+func (page SearchPage) InputPercent(connection Connection) float64 {
+	return page.data.InputPercent(connection)
+}
+
+// Percentile returns the connection's strength percentile in the dataset.
+func (page SearchPage) Percentile(connection Connection) float64 {
+	return page.data.Percentile(connection.strength)
+}
+
+// searchLink returns a relative URL that repeats the request's search as
+// a GET, so POSTed results can be shared.
+func searchLink(r *http.Request) string {
+	query := make(url.Values)
+	for key, values := range r.Form {
+		if key != "format" {
+			query[key] = values
+		}
+	}
+	return "search?" + query.Encode()
+}
+
+// getSearchHTML returns an HTML page of connections matching the request's
+// pre and post patterns.  If opts.Normalize is set, each connection also
+// shows its share of the postsynaptic cell's total input.
+func (s *Server) getSearchHTML(r *http.Request, opts SearchOptions) (string, error) {
+	start := time.Now()
+	defer func() { s.metrics.observeSearchRender(time.Since(start)) }()
+	d, err := s.requestData(r)
+	if err != nil {
+		return "", err
+	}
+	preMatches, postMatches, connections, unfiltered, err := s.searchWithMatches(r, d, opts)
+	if err != nil {
+		return "", err
+	}
+	connections.SortBy(opts.Sort)
+	page := SearchPage{
+		Pre:         r.FormValue("pre"),
+		Post:        r.FormValue("post"),
+		Link:        searchLink(r),
+		Opts:        opts,
+		Total:       len(connections),
+		Unfiltered:  unfiltered,
+		Synapses:    connections.TotalStrength(),
+		Connections: connections.Page(opts.Offset, opts.Limit),
+		PreMatches:  sortedNames(preMatches),
+		PostMatches: sortedNames(postMatches),
+		PrefixTerms: append(d.PrefixTerms(r.FormValue("pre"), opts), d.PrefixTerms(r.FormValue("post"), opts)...),
+		data:        d,
+		ranks:       connections.StrengthRanks(),
+	}
+	if max := s.config.MaxResults; max > 0 && len(page.Connections) > max {
+		page.Connections = page.Connections[:max]
+		page.Truncated = true
+	}
+	if len(connections) == 0 && !opts.Regex {
+		if len(preMatches) == 0 {
+			page.PreSuggestions = d.NearMisses(page.Pre)
+		}
+		if len(postMatches) == 0 {
+			page.PostSuggestions = d.NearMisses(page.Post)
+		}
+	}
+	var text strings.Builder
+	if err := searchTemplate.Execute(&text, page); err != nil {
+		slog.Error("rendering search results", "err", err)
+	}
+	return text.String(), nil
+}
+
+// ReadCellsCSV reads cell names from the first column of a CSV file, in the
+// order that matches the connectivity matrix.  Any header row is skipped.
+// Names are normalized as described for CSVOptions.normalizeName.
+// Repeated names are an error listing each one with its line numbers,
+// since they would misalign the rows and columns of the matrix.
+func ReadCellsCSV(filename string, opts CSVOptions) (names CellList, err error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatalf("ERROR: Failed to open cell names csv file: %s [%s]\n",
-			filename, err)
+		return nil, fmt.Errorf("failed to open cell names csv file %s: %w", filename, err)
 	}
 	defer file.Close()
 
 	// Reserve enough for the nature paper # of cells
 	names = make(CellList, 0, 390)
-	cellSet = make(map[string]bool)
-	csvReader := csv.NewReader(file)
+	lines := make(map[string][]int)
+	var duplicates []string
+	normalized := 0
+	csvReader := opts.newReader(file)
+	if _, err := opts.readHeader(csvReader, filename); err != nil {
+		return nil, err
+	}
 
 	// Read all connectivity matrix
 	for {
@@ -285,17 +1484,32 @@ func ReadCellsCSV(filename string) (names CellList) {
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			log.Fatalf("Error on reading cell list name file (%s): %s", filename, err)
-		} else if items[0] == "" {
+			return nil, fmt.Errorf("error on reading cell list name file (%s): %w", filename, err)
+		}
+		name := opts.normalizeName(items[0])
+		if name != items[0] {
+			normalized++
+		}
+		if name == "" {
 			continue
-		} else {
-			names = append(names, items[0])
-			cellSet[items[0]] = true
 		}
+		line, _ := csvReader.FieldPos(0)
+		if len(lines[name]) == 1 {
+			duplicates = append(duplicates, name)
+		}
+		names = append(names, name)
+		lines[name] = append(lines[name], line)
 	}
-	if len(cellSet) != len(names) {
-	    log.Fatalf("Not all names in %s are distinct!  %d names (%d distinct)\n",
-	        filename, len(names), len(cellSet))
+	if normalized > 0 {
+		log.Printf("Normalized whitespace in %d cell names from %s.\n", normalized, filename)
+	}
+	if len(duplicates) > 0 {
+		repeats := make([]string, len(duplicates))
+		for i, name := range duplicates {
+			repeats[i] = fmt.Sprintf("%q on lines %s", name, joinInts(lines[name]))
+		}
+		return nil, fmt.Errorf("not all names in %s are distinct: %s",
+			filename, strings.Join(repeats, "; "))
 	}
 	log.Printf("Read in %d cell names from %s.\n", len(names), filename)
 	return
@@ -315,55 +1529,273 @@ func colCode(bodyNum int) string {
 }
 
 
-func ReadConnectionsCSV(names CellList, filename string) (connects NamedConnectome) {
+// joinInts returns the numbers separated by commas.
+func joinInts(numbers []int) string {
+	strs := make([]string, len(numbers))
+	for i, n := range numbers {
+		strs[i] = strconv.Itoa(n)
+	}
+	return strings.Join(strs, ", ")
+}
+
+// ReadConnectionsCSV reads a square connectivity matrix whose rows and
+// columns are ordered as the given cell names.  The diagonal is recorded
+// like any other entry, so self-connections appear in the connectome.
+// Rows the CSV reader can't parse are skipped with a warning, while a
+// strength that can't be parsed is an error giving its line.  The matrix
+// must have exactly one row and one column per cell name, and a mismatch
+// is an error giving the first offending row.  With a HeaderNames option,
+// the header row must list the cell names in order.
+func ReadConnectionsCSV(names CellList, filename string, opts CSVOptions) (connects *NamedConnectome, err error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatalf("ERROR: Failed to open connectome csv file: %s [%s]\n",
-			filename, err)
+		return nil, fmt.Errorf("failed to open connectome csv file %s: %w", filename, err)
 	}
 	defer file.Close()
 
-	connects = make(NamedConnectome)
-	csvReader := csv.NewReader(file)
+	connects = NewNamedConnectome()
+	csvReader := opts.newReader(file)
+	// Row lengths are checked against the cell names below, so a short
+	// row is reported with its line rather than skipped.
+	csvReader.FieldsPerRecord = -1
+	header, err := opts.readHeader(csvReader, filename)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Header == HeaderNames {
+		if len(header) != len(names) {
+			return nil, fmt.Errorf("%s header has %d columns but there are %d cell names",
+				filename, len(header), len(names))
+		}
+		for i, name := range header {
+			if opts.normalizeName(name) != names[i] {
+				return nil, fmt.Errorf("%s header column %d is %q but the cell name is %q",
+					filename, i+1, name, names[i])
+			}
+		}
+	}
 
 	bodyNum := 0
+	lost := 0
 	// Read all connectivity matrix
 	for {
 		items, err := csvReader.Read()
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			log.Println("Warning:", err)
-		} else if items[0] == "" {
+			slog.Warn("skipping unreadable connectivity row", "file", filename, "err", err)
+			continue
+		}
+		line, _ := csvReader.FieldPos(0)
+		if items[0] == "" {
 			continue
+		} else if bodyNum >= len(names) {
+			return nil, fmt.Errorf("%s line %d: matrix has more rows than the %d cell names; first extra is row %d",
+				filename, line, len(names), bodyNum)
 		} else if len(items) != len(names) {
-			log.Fatalf("ERROR: CSV has inconsistent # of columns (%d) vs cell names supplied (%d)!",
-				len(items), len(names))
-		} else {
-		    
-			preName := names[bodyNum]
-			for i := 0; i < len(items); i++ {
-				postName := names[i]
-				strength, err := strconv.Atoi(items[i])
-				if err != nil {
-					log.Fatalln("ERROR: Could not parse CSV line:", items, "\nError:", err)
-				}
-				if strength > 0 {
-					connects.AddConnection(preName, postName, strength)
-				}
+			return nil, fmt.Errorf("%s line %d: row %d (%s) has %d columns but there are %d cell names",
+				filename, line, bodyNum, names[bodyNum], len(items), len(names))
+		}
+		preName := names[bodyNum]
+		for i := 0; i < len(items); i++ {
+			postName := names[i]
+			strength, rounded, err := opts.parseStrength(items[i])
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: could not parse CSV value %q: %w",
+					filename, line, items[i], err)
+			}
+			if rounded {
+				lost++
+			}
+			if strength > 0 {
+				connects.AddConnection(preName, postName, strength)
+			}
+		}
+		bodyNum++
+	}
+	if bodyNum != len(names) {
+		return nil, fmt.Errorf("%s: matrix has %d rows but there are %d cell names; first missing is row %d (%s)",
+			filename, bodyNum, len(names), bodyNum, names[bodyNum])
+	}
+	opts.warnLost(filename, lost)
+	return
+}
+
+// reloadAllowed returns true if the request may trigger a reload: with a
+// -reload-token, if it carries that token as "Authorization: Bearer
+// <token>", and otherwise only if it comes from a loopback address and
+// not through a proxy.  A reverse proxy on the same host connects from
+// loopback for every client, so a request with forwarding headers is
+// refused, but a proxy that sets none can't be told apart and needs the
+// token.
+func (s *Server) reloadAllowed(r *http.Request) bool {
+	if token := s.config.ReloadToken; token != "" {
+		given, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return found && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+	}
+	for _, header := range []string{"Forwarded", "X-Forwarded-For", "X-Real-IP"} {
+		if r.Header.Get(header) != "" {
+			return false
+		}
+	}
+	return isLoopback(r.RemoteAddr)
+}
+
+// isLoopback returns true if a host:port address is on a loopback
+// interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Handler for reloading the CSV files without restarting the server.
+// Requires POST, and is refused with a 403 unless reloadAllowed.
+func (s *Server) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.reloadAllowed(r) {
+		http.Error(w, "Reload not allowed from this client.", http.StatusForbidden)
+		return
+	}
+	if err := s.Load(); err != nil {
+		slog.Error("reload failed", "err", err)
+		http.Error(w, "Reload failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d := s.currentData()
+	fmt.Fprintf(w, "Reloaded %d cells and %d connections.\n", len(d.Cells), d.Connectivity.NumConnections())
+}
+
+// Health reports whether the server has data loaded and ready to serve.
+type Health struct {
+	Cells   int    `json:"cells"`
+	Ready   bool   `json:"ready"`
+	Version string `json:"version"`
+}
+
+// Handler for readiness probes.  Returns 200 once cells and connections
+// have been loaded, and 503 before that or if the loaded data is empty.
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	health := Health{Version: versionString()}
+	if d := s.currentData(); d != nil {
+		health.Cells = len(d.Cells)
+		health.Ready = health.Cells > 0 && d.Connectivity.NumConnections() > 0
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !health.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		slog.Error("writing health response", "err", err)
+	}
+}
+
+// ReadIDMapCSV reads "id,name" rows mapping body ids to cell names, with
+// the options' delimiter and name normalization so the names match those
+// of the other files.  A first row whose id isn't an integer is treated
+// as a header.
+func ReadIDMapCSV(filename string, opts CSVOptions) (names map[string]string, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open id map csv file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	names = make(map[string]string)
+	csvReader := opts.newReader(file)
+	csvReader.FieldsPerRecord = 2
+	normalized := 0
+	for {
+		items, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error on reading id map file (%s): %w", filename, err)
+		}
+		line, _ := csvReader.FieldPos(0)
+		id := strings.TrimSpace(items[0])
+		if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+			if line == 1 {
+				continue
 			}
-    		bodyNum++
+			return nil, fmt.Errorf("%s line %d: could not parse body id %q: %w",
+				filename, line, items[0], err)
+		}
+		if name, found := names[id]; found {
+			return nil, fmt.Errorf("%s line %d: body id %s already maps to %q",
+				filename, line, id, name)
 		}
+		name := opts.normalizeName(items[1])
+		if name != items[1] {
+			normalized++
+		}
+		names[id] = name
+	}
+	if normalized > 0 {
+		log.Printf("Normalized whitespace in %d cell names from %s.\n", normalized, filename)
 	}
+	log.Printf("Read in %d body ids from %s.\n", len(names), filename)
 	return
 }
 
+// ReadEdgeListCSV reads a connectome from "pre,post,strength" rows.  The
+// first row is skipped only if the options say there is a header, so a
+// strength that can't be parsed on any other row is an error giving its
+// line number.  Repeated (pre, post) rows are summed.
+func ReadEdgeListCSV(filename string, opts CSVOptions) (connects *NamedConnectome, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open edge list csv file %s: %w", filename, err)
+	}
+	defer file.Close()
 
+	connects = NewNamedConnectome()
+	csvReader := opts.newReader(file)
+	csvReader.FieldsPerRecord = 3
+	if _, err := opts.readHeader(csvReader, filename); err != nil {
+		return nil, err
+	}
+	normalized, lost := 0, 0
+	for {
+		items, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error on reading edge list file (%s): %w", filename, err)
+		}
+		line, _ := csvReader.FieldPos(0)
+		strength, rounded, err := opts.parseStrength(strings.TrimSpace(items[2]))
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: could not parse strength %q: %w",
+				filename, line, items[2], err)
+		}
+		if rounded {
+			lost++
+		}
+		pre, post := opts.normalizeName(items[0]), opts.normalizeName(items[1])
+		if pre != items[0] || post != items[1] {
+			normalized++
+		}
+		if strength > 0 {
+			connects.AddConnection(pre, post, strength)
+		}
+	}
+	if normalized > 0 {
+		log.Printf("Normalized whitespace in cell names of %d edges from %s.\n", normalized, filename)
+	}
+	opts.warnLost(filename, lost)
+	return
+}
 
 func main() {
 	flag.BoolVar(showHelp, "h", false, "Show help message")
-	flag.Usage = func() { 
-		fmt.Printf(helpMessage, DefaultCellsFilename, DefaultConnectivityFilename) 
+	flag.Usage = func() {
+		fmt.Printf(helpMessage, DefaultCellsFilename, DefaultConnectivityFilename,
+			DefaultFloatScale, DefaultReadTimeout, DefaultWriteTimeout, DefaultIdleTimeout,
+			DefaultSearchCacheSize, DefaultMaxResults, DefaultMaxBodyBytes)
 	}
 	flag.Parse()
 
@@ -375,46 +1807,127 @@ func main() {
 		flag.Usage()
 		os.Exit(0)
 	}
+	if *showVersion {
+		fmt.Println("medulla_one_column", versionString())
+		os.Exit(0)
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fmt.Fprintln(os.Stderr, "ERROR: -tls-cert and -tls-key must be given together to serve HTTPS.")
+		os.Exit(2)
+	}
+	level, err := parseLogLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(2)
+	}
 	if *runDebug {
+		level = slog.LevelDebug
+	}
+	setupLogging(level)
+	if err := applyEnvDefaults(); err != nil {
+		fatal("bad environment default", "err", err)
+	}
+	pages, pagesSource := webPagesFS()
+	if _, err := fs.Stat(pages, "index.html"); err != nil {
+		slog.Warn("no index.html; only the API will work", "pages", pagesSource)
+	} else {
+		log.Printf("Serving web pages from %s.\n", pagesSource)
+	}
+	if level == slog.LevelDebug {
 		fmt.Println("Running in Debug mode...")
 	}
 
-	// Read the named bodies
-	cells := ReadCellsCSV(*cellsFilename)
+	comma, err := ParseDelimiter(*csvDelimiter)
+	if err != nil {
+		fatal("bad -delimiter", "err", err)
+	}
+	csvOptions := CSVOptions{Header: *csvHeader, Comma: comma, CollapseSpaces: *collapseSpaces}
+	if *floatStrengths {
+		if *floatScale <= 0 {
+			fatal("-float-scale must be positive", "value", *floatScale)
+		}
+		csvOptions.Scale = *floatScale
+	}
+	server := NewServer(Config{
+		CellsFilename:         *cellsFilename,
+		Connectomes:           connectomeFiles.files,
+		ConnectivityFilename2: *connectivityFilename2,
+		Format:                *connectivityFormat,
+		IDMapFilename:         *idmapFilename,
+		CSV:                   csvOptions,
+		IgnoreCase:            *ignoreCase,
+		MaxResults:            *maxResults,
+		WebPages:              pages,
+		AllowOrigin:           *allowOrigin,
+		NoCompress:            *noCompress,
+		SearchCacheSize:       *searchCacheSize,
+		MaxBodyBytes:          *maxBodyBytes,
+		ReloadToken:           *reloadToken,
+	})
+
+	// Read the named bodies and their connections
+	if err := server.Load(); err != nil {
+		fatal("could not load data", "err", err)
+	}
 
-	// Read the connections
-	connectivity = ReadConnectionsCSV(cells, *connectivityFilename)
-	
-	for name, _ := range cellSet {
-	    _, found := connectivity[name]
-	    if !found {
-	        // Check to see if it had presynaptic connections.
-	        for pre, _ := range cellSet {
-	            _, found = connectivity[pre][name]
-	            if found {
-	                fmt.Printf("Cell is only postsynaptic: %s\n", name)
-	                break
-	            }
-	        }
-	    }
+	data := server.currentData()
+	connectivity := data.Connectivity
+	for name, _ := range data.CellSet {
+		if !connectivity.HasOutgoing(name) {
+			// Check to see if it had presynaptic connections.
+			for pre, _ := range data.CellSet {
+				_, found := connectivity.ConnectionStrength(pre, name)
+				if found {
+					fmt.Printf("Cell is only postsynaptic: %s\n", name)
+					break
+				}
+			}
+		}
 	}
 
-	fmt.Printf("Ready to serve connections between %d neurons...\n", len(connectivity))
+	fmt.Printf("Ready to serve connections between %d neurons...\n", connectivity.NumPresynaptic())
 
-	// Listen and serve HTTP requests using address and don't let stay-alive
-	// connections hog goroutines for more than an hour.
-	// See for discussion:
+	// Listen and serve HTTP requests using address.  The read timeout was
+	// originally an hour simply so stay-alive connections couldn't hog
+	// goroutines forever.  See for discussion:
 	// http://stackoverflow.com/questions/10971800/golang-http-server-leaving-open-goroutines
-	fmt.Printf("Web server listening at %s ...\n", *httpAddress)
+	// IdleTimeout now closes idle stay-alive connections directly, so the
+	// read and write timeouts can be short enough that slow clients can't
+	// tie up the server.
+	// Listening before serving catches a bad or busy address, and with a
+	// port of 0, as in "-http :0", reports the free port that was chosen.
+	listener, err := net.Listen("tcp", *httpAddress)
+	if err != nil {
+		fatal("could not listen", "addr", *httpAddress, "err", err)
+	}
+	if *reloadToken == "" {
+		slog.Warn("without -reload-token, any client connecting from loopback can POST /admin/reload; "+
+			"set one if a reverse proxy on this host forwards requests", "addr", listener.Addr())
+	}
+	useTLS := *tlsCert != ""
+	if useTLS {
+		fmt.Printf("Web server listening for HTTPS at %s ...\n", listener.Addr())
+	} else {
+		fmt.Printf("Web server listening at %s ...\n", listener.Addr())
+	}
 
 	src := &http.Server{
-		Addr:        *httpAddress,
-		ReadTimeout: 1 * time.Hour,
+		Addr:         *httpAddress,
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
 	}
 
-	http.HandleFunc("/search", searchHandler)
-	http.HandleFunc("/", mainHandler)
+	src.Handler = server
 
 	// Serve it up!
-	src.ListenAndServe()
+	if useTLS {
+		err = src.ServeTLS(listener, *tlsCert, *tlsKey)
+	} else {
+		err = src.Serve(listener)
+	}
+	// Serve only returns early with ErrServerClosed after a shutdown.
+	if !errors.Is(err, http.ErrServerClosed) {
+		fatal("server stopped", "err", err)
+	}
 }
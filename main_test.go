@@ -0,0 +1,946 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// writeFile writes a test fixture into a temporary directory and returns
+// its name.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestReadCSVKeepsMatrixOrder(t *testing.T) {
+	cellsFile := writeFile(t, "cells.csv", "C 3\nA 1\nB 2\n")
+	matrixFile := writeFile(t, "matrix.csv", "0,5,0\n0,0,7\n2,0,0\n")
+	cells, err := ReadCellsCSV(cellsFile, CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (CellList{"C 3", "A 1", "B 2"}); !reflect.DeepEqual(cells, want) {
+		t.Fatalf("cells = %q, want the file order %q", cells, want)
+	}
+	connects, err := ReadConnectionsCSV(cells, matrixFile, CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		pre, post string
+		strength  int
+	}{
+		{"C 3", "A 1", 5},
+		{"A 1", "B 2", 7},
+		{"B 2", "C 3", 2},
+		{"A 1", "C 3", 0},
+	} {
+		if got, _ := connects.ConnectionStrength(test.pre, test.post); got != test.strength {
+			t.Errorf("strength %s -> %s = %d, want %d", test.pre, test.post, got, test.strength)
+		}
+	}
+	if sorted := cells.Sorted(); !reflect.DeepEqual(sorted, CellsByName{"A 1", "B 2", "C 3"}) {
+		t.Errorf("Sorted() = %q, want ascending order", sorted)
+	}
+	if cells[0] != "C 3" {
+		t.Errorf("Sorted() reordered the matrix order list: %q", cells)
+	}
+}
+
+// testNames is a small set of cell names for matching tests.
+var testNames = map[string]bool{
+	"L1 209":    true,
+	"L2 212":    true,
+	"Mi1 215":   true,
+	"Mi10 3687": true,
+	"Tm1 209":   true,
+	"LC10":      true,
+}
+
+func TestMatchNames(t *testing.T) {
+	index := NewNameIndex(testNames)
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{"prefix", []string{"Mi1*"}, []string{"Mi1 215", "Mi10 3687"}},
+		{"suffix", []string{"*209"}, []string{"L1 209", "Tm1 209"}},
+		{"infix", []string{"*1 2*"}, []string{"L1 209", "Mi1 215", "Tm1 209"}},
+		{"exact", []string{"L2 212"}, []string{"L2 212"}},
+		{"exact without match", []string{"L2"}, []string{}},
+		{"everything", []string{"*"}, []string{"L1 209", "L2 212", "LC10", "Mi1 215", "Mi10 3687", "Tm1 209"}},
+		{"empty", []string{""}, []string{}},
+		{"no patterns", nil, []string{}},
+	}
+	for _, test := range tests {
+		for _, indexed := range []*NameIndex{nil, index} {
+			got := sortedNames(matchNames(testNames, indexed, test.patterns, false))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s (indexed %t): %q matched %q, want %q",
+					test.name, indexed != nil, test.patterns, got, test.want)
+			}
+		}
+	}
+}
+
+func TestMatchingNamesBlankPatterns(t *testing.T) {
+	for _, patterns := range [][]string{{""}, {"   "}, {"\t", ""}, {" \u00a0 "}} {
+		matches := MatchingNames(testNames, patterns)
+		if matches == nil || len(matches) != 0 {
+			t.Errorf("MatchingNames(%q) = %#v, want an empty slice", patterns, matches)
+		}
+	}
+}
+
+func TestReadConnectionsCSVErrors(t *testing.T) {
+	cells := CellList{"A", "B", "C"}
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"short row", "0,1,2\n3,4\n5,6,7\n", "line 2"},
+		{"long row", "0,1,2\n3,4,5\n6,7,8,9\n", "line 3"},
+		{"bad value", "0,1,2\n3,x,5\n6,7,8\n", "line 2"},
+		{"truncated", "0,1,2\n3,4,5\n6,7", "line 3"},
+		{"extra row", "0,1,2\n3,4,5\n6,7,8\n9,9,9\n", "line 4"},
+		{"missing row", "0,1,2\n3,4,5\n", "first missing is row 2 (C)"},
+		{"empty", "", "first missing is row 0 (A)"},
+	}
+	for _, test := range tests {
+		_, err := ReadConnectionsCSV(cells, writeFile(t, "matrix.csv", test.content), CSVOptions{})
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		} else if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: error %q does not mention %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestReadEdgeListCSVHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		content string
+		want    string
+	}{
+		{"no header", HeaderNone, "A,B,5\nB,A,7\n", ""},
+		{"skipped header", HeaderSkip, "pre,post,strength\nA,B,5\nB,A,7\n", ""},
+		{"bad first row", HeaderNone, "A,B,x\nA,B,5\nB,A,7\n", "line 1"},
+		{"unskipped header", HeaderNone, "pre,post,strength\nA,B,5\nB,A,7\n", "line 1"},
+		{"bad later row", HeaderSkip, "pre,post,strength\nA,B,5\nB,A,x\n", "line 3"},
+	}
+	for _, test := range tests {
+		connects, err := ReadEdgeListCSV(writeFile(t, "edges.csv", test.content), CSVOptions{Header: test.header})
+		if test.want != "" {
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("%s: error %v, want one mentioning %q", test.name, err, test.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if connects.Strength("A", "B") != 5 || connects.Strength("B", "A") != 7 {
+			t.Errorf("%s: wrong strengths %v", test.name, connects.AllConnections())
+		}
+	}
+}
+
+func TestMatchNamesIgnoreCase(t *testing.T) {
+	index := NewNameIndex(testNames)
+	tests := []struct {
+		patterns   []string
+		ignoreCase bool
+		want       []string
+	}{
+		{[]string{"mi1*"}, true, []string{"Mi1 215", "Mi10 3687"}},
+		{[]string{"mi1*"}, false, []string{}},
+		{[]string{"MI1 215"}, true, []string{"Mi1 215"}},
+		{[]string{"MI1 215"}, false, []string{}},
+		{[]string{"*lc*"}, true, []string{"LC10"}},
+		{[]string{"*209"}, true, []string{"L1 209", "Tm1 209"}},
+		{[]string{"l*", "-L1*"}, true, []string{"L2 212", "LC10"}},
+	}
+	for _, test := range tests {
+		for _, indexed := range []*NameIndex{nil, index} {
+			got := sortedNames(matchNames(testNames, indexed, test.patterns, test.ignoreCase))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%q with ignoreCase %t (indexed %t) matched %q, want %q",
+					test.patterns, test.ignoreCase, indexed != nil, got, test.want)
+			}
+		}
+	}
+}
+
+// autapseMatrix connects cells A, B and C, with self-connections on A and C.
+const autapseMatrix = "4,5,0\n2,0,7\n3,0,1\n"
+
+func TestSelfConnections(t *testing.T) {
+	connects, err := ReadConnectionsCSV(CellList{"A", "B", "C"}, writeFile(t, "matrix.csv", autapseMatrix), CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ConnectionList{{"A", "A", 4}, {"C", "C", 1}}
+	if got := connects.SelfConnections(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SelfConnections() = %v, want %v", got, want)
+	}
+}
+
+func TestExcludeSelf(t *testing.T) {
+	cells := CellList{"A", "B", "C"}
+	connects, err := ReadConnectionsCSV(cells, writeFile(t, "matrix.csv", autapseMatrix), CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(Config{})
+	s.SetData([]*Dataset{NewDataset(cells, connects)}, nil)
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, rec.Code)
+		}
+		return rec.Body.String()
+	}
+	if body := get("/api/autapses"); body != `[{"pre":"A","post":"A","strength":4},{"pre":"C","post":"C","strength":1}]`+"\n" {
+		t.Errorf("/api/autapses = %s", body)
+	}
+	for _, test := range []struct {
+		query string
+		self  bool
+	}{
+		{"", true},
+		{"&exclude_self=0", true},
+		{"&exclude_self=1", false},
+	} {
+		html := get("/search?pre=*&post=*" + test.query)
+		if self := strings.Contains(html, "<td>A</td><td>A</td>"); self != test.self {
+			t.Errorf("search%s: row A -> A shown %t, want %t", test.query, self, test.self)
+		}
+		if !strings.Contains(html, "<td>B</td><td>C</td>") {
+			t.Errorf("search%s: row B -> C missing", test.query)
+		}
+		var connections []struct{ Pre, Post string }
+		if err := json.Unmarshal([]byte(get("/api/search?pre=*&post=*"+test.query)), &connections); err != nil {
+			t.Fatal(err)
+		}
+		self := 0
+		for _, c := range connections {
+			if c.Pre == c.Post {
+				self++
+			}
+		}
+		if want := map[bool]int{true: 2, false: 0}[test.self]; self != want || len(connections) != 4+want {
+			t.Errorf("/api/search%s: %d connections with %d to self, want %d with %d",
+				test.query, len(connections), self, 4+want, want)
+		}
+	}
+}
+
+func TestConnectionListStableOrder(t *testing.T) {
+	want := ConnectionList{
+		{"B", "A", 9},
+		{"A", "B", 3},
+		{"A", "C", 3},
+		{"B", "C", 3},
+		{"C", "A", 3},
+		{"C", "B", 1},
+	}
+	for i := 0; i < 20; i++ {
+		list := make(ConnectionList, len(want))
+		copy(list, want)
+		rand.New(rand.NewSource(int64(i))).Shuffle(len(list), list.Swap)
+		list.SortByStrength()
+		if !reflect.DeepEqual(list, want) {
+			t.Fatalf("sort %d gave %v, want %v", i, list, want)
+		}
+	}
+}
+
+func TestSearchEscapesHTML(t *testing.T) {
+	d, err := LoadDataset(writeFile(t, "cells.csv", "L1 209\n<script>alert(1)</script>\n"),
+		writeFile(t, "matrix.csv", "0,3\n2,0\n"), FormatMatrix, CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(Config{})
+	s.SetData([]*Dataset{d}, nil)
+	for _, pre := range []string{"<script>*", "<b>bold</b>,*"} {
+		req := httptest.NewRequest(http.MethodPost, "/search",
+			strings.NewReader(url.Values{"pre": {pre}, "post": {"*"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		body := rec.Body.String()
+		if strings.Contains(body, "<script>") || strings.Contains(body, "<b>") {
+			t.Errorf("search for %q: unescaped markup in %s", pre, body)
+		}
+		if !strings.Contains(body, "&lt;script&gt;alert(1)&lt;/script&gt;") {
+			t.Errorf("search for %q: escaped cell name missing from %s", pre, body)
+		}
+	}
+}
+
+// benchmarkPatterns are prefix patterns typical of searches for cell
+// families.
+var benchmarkPatterns = []string{"Mi1*", "Tm*", "L1*", "T4*", "C2*"}
+
+// benchmarkNames returns the set of names in the sample cell names file.
+func benchmarkNames(b *testing.B) map[string]bool {
+	b.Helper()
+	cells, err := ReadCellsCSV(DefaultCellsFilename, CSVOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return cells.NameSet()
+}
+
+func BenchmarkMatchNamesLinear(b *testing.B) {
+	names := benchmarkNames(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matchNames(names, nil, benchmarkPatterns, false)
+	}
+}
+
+func BenchmarkMatchNamesIndexed(b *testing.B) {
+	index := NewNameIndex(benchmarkNames(b))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Matching(benchmarkPatterns, false)
+	}
+}
+
+func TestReadCSVHeaders(t *testing.T) {
+	want := CellList{"A 1", "B 2"}
+	tests := []struct {
+		name      string
+		header    string
+		cells     string
+		matrix    string
+		wantError string
+	}{
+		{"no header", HeaderNone, "A 1\nB 2\n", "0,5\n7,0\n", ""},
+		{"default", "", "A 1\nB 2\n", "0,5\n7,0\n", ""},
+		{"skipped", HeaderSkip, "name\nA 1\nB 2\n", "x,y\n0,5\n7,0\n", ""},
+		{"names", HeaderNames, "name\nA 1\nB 2\n", "A 1,B 2\n0,5\n7,0\n", ""},
+		{"names mismatch", HeaderNames, "name\nA 1\nB 2\n", "B 2,A 1\n0,5\n7,0\n", "header column 1"},
+		{"names too short", HeaderNames, "name\nA 1\nB 2\n", "A 1\n0,5\n7,0\n", "header has 1 columns"},
+		{"header not skipped", HeaderNone, "A 1\nB 2\n", "A 1,B 2\n0,5\n7,0\n", "line 1"},
+	}
+	for _, test := range tests {
+		opts := CSVOptions{Header: test.header}
+		cells, err := ReadCellsCSV(writeFile(t, "cells.csv", test.cells), opts)
+		if err != nil {
+			t.Errorf("%s: reading cells: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(cells, want) {
+			t.Errorf("%s: cells = %q, want %q", test.name, cells, want)
+		}
+		connects, err := ReadConnectionsCSV(cells, writeFile(t, "matrix.csv", test.matrix), opts)
+		if test.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantError) {
+				t.Errorf("%s: error %v, want one mentioning %q", test.name, err, test.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: reading matrix: %v", test.name, err)
+		} else if connects.Strength("A 1", "B 2") != 5 || connects.Strength("B 2", "A 1") != 7 {
+			t.Errorf("%s: wrong strengths %v", test.name, connects.AllConnections())
+		}
+	}
+}
+
+func TestReadTSV(t *testing.T) {
+	comma, err := ParseDelimiter(`\t`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := CSVOptions{Comma: comma}
+	cells, err := ReadCellsCSV(filepath.Join("testdata", "cells.tsv"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (CellList{"Mi1 215", "Tm3 92", "L1 209"}); !reflect.DeepEqual(cells, want) {
+		t.Fatalf("cells = %q, want %q", cells, want)
+	}
+	connects, err := ReadConnectionsCSV(cells, filepath.Join("testdata", "matrix.tsv"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := connects.AllConnections(); len(got) != 3 ||
+		connects.Strength("Mi1 215", "Tm3 92") != 4 || connects.Strength("Tm3 92", "L1 209") != 9 ||
+		connects.Strength("L1 209", "Mi1 215") != 1 {
+		t.Errorf("wrong connections %v", got)
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	tests := []struct {
+		value string
+		comma rune
+		ok    bool
+	}{
+		{",", ',', true},
+		{";", ';', true},
+		{`\t`, '\t', true},
+		{"\t", '\t', true},
+		{"", 0, false},
+		{";;", 0, false},
+		{`"`, 0, false},
+		{"\n", 0, false},
+	}
+	for _, test := range tests {
+		comma, err := ParseDelimiter(test.value)
+		if (err == nil) != test.ok || comma != test.comma {
+			t.Errorf("ParseDelimiter(%q) = %q, %v; want %q, ok %t", test.value, comma, err, test.comma, test.ok)
+		}
+	}
+}
+
+func TestParseStrength(t *testing.T) {
+	tests := []struct {
+		value    string
+		scale    float64
+		strength int
+		lost     bool
+	}{
+		{"12", 0, 12, false},
+		{"0", 0, 0, false},
+		{"0.75", DefaultFloatScale, 750, false},
+		{"3", DefaultFloatScale, 3000, false},
+		{"0.0004", DefaultFloatScale, 0, true},
+		{"0.0004", 10000, 4, false},
+		{"0", DefaultFloatScale, 0, false},
+	}
+	for _, test := range tests {
+		opts := CSVOptions{Scale: test.scale}
+		strength, lost, err := opts.parseStrength(test.value)
+		if err != nil {
+			t.Errorf("parseStrength(%q) with scale %g: %v", test.value, test.scale, err)
+			continue
+		}
+		if strength != test.strength || lost != test.lost {
+			t.Errorf("parseStrength(%q) with scale %g = %d, %t; want %d, %t",
+				test.value, test.scale, strength, lost, test.strength, test.lost)
+		}
+	}
+	if _, _, err := (CSVOptions{}).parseStrength("0.75"); err == nil {
+		t.Errorf("parseStrength(\"0.75\") without -float: expected an error")
+	}
+	for _, value := range []string{"NaN", "Inf", "-Inf", "1e300", "-1e300", "1e400"} {
+		if strength, _, err := (CSVOptions{Scale: DefaultFloatScale}).parseStrength(value); err == nil {
+			t.Errorf("parseStrength(%q) with -float = %d, expected an error", value, strength)
+		}
+	}
+}
+
+func TestReadIDMapCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		opts    CSVOptions
+	}{
+		{"comma", "id,name\n215,Mi1 215\n3912,Mi1 3912\n", CSVOptions{}},
+		{"semicolon", "id;name\n215;Mi1 215\n3912;Mi1 3912\n", CSVOptions{Comma: ';'}},
+		{"tab", "215\tMi1 215\n3912\tMi1 3912\n", CSVOptions{Comma: '\t'}},
+		{"stray spaces", "215,Mi1 215 \n3912,\u00a0Mi1 3912\n", CSVOptions{}},
+	}
+	for _, test := range tests {
+		names, err := ReadIDMapCSV(writeFile(t, "ids.csv", test.content), test.opts)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(names) != 2 || names["215"] != "Mi1 215" || names["3912"] != "Mi1 3912" {
+			t.Errorf("%s: got %q", test.name, names)
+		}
+	}
+}
+
+// testCells are the cells of the test server's dataset, in matrix order.
+var testCells = CellList{"L1 209", "L2 212", "Mi1 215", "Mi10 3687", "Tm1 209", "<script>alert(1)</script>"}
+
+// newTestServer returns a test HTTP server for a small in-memory dataset
+// whose web pages are in a directory next to a file that must never be
+// served.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	root := t.TempDir()
+	webDir := filepath.Join(root, "web")
+	for name, content := range map[string]string{
+		"index.html":    "<h1>Search</h1>",
+		NotFoundPage:    "<h1>Page not found</h1>",
+		"css/site.css":  "body {}",
+		"../secret.txt": "top secret",
+	} {
+		filename := filepath.Join(webDir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nc := NewNamedConnectome()
+	nc.AddConnection("L1 209", "Mi1 215", 9)
+	nc.AddConnection("L1 209", "Tm1 209", 5)
+	nc.AddConnection("L2 212", "Mi1 215", 3)
+	nc.AddConnection("<script>alert(1)</script>", "Mi1 215", 2)
+	nc.AddConnection("Mi1 215", "Tm1 209", 1)
+	s := NewServer(Config{WebPages: os.DirFS(webDir)})
+	s.SetData([]*Dataset{NewDataset(testCells, nc)}, nil)
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// fetch returns the status and body of a GET of the path on the server,
+// following any redirects.
+func fetch(t *testing.T, server *httptest.Server, path string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestWebPages(t *testing.T) {
+	server := newTestServer(t)
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/", http.StatusOK, "<h1>Search</h1>"},
+		{"/index.html", http.StatusOK, "<h1>Search</h1>"},
+		{"/css/site.css", http.StatusOK, "body {}"},
+		{"/nope.html", http.StatusNotFound, "Page not found"},
+		{"/css/", http.StatusNotFound, "Page not found"},
+		{"/../main.go", http.StatusNotFound, "Page not found"},
+	}
+	for _, test := range tests {
+		status, body := fetch(t, server, test.path)
+		if status != test.status || !strings.Contains(body, test.body) {
+			t.Errorf("GET %s = %d %q, want %d containing %q", test.path, status, body, test.status, test.body)
+		}
+	}
+}
+
+func TestWebPathTraversal(t *testing.T) {
+	server := newTestServer(t)
+	noRedirects := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	payloads := []string{
+		"/../secret.txt",
+		"/css/../../secret.txt",
+		"/%2e%2e/secret.txt",
+		"/%2E%2E/%2E%2E/secret.txt",
+		"/..%2fsecret.txt",
+		"/css/..%2f..%2fsecret.txt",
+		"/..%5csecret.txt",
+		"/css/..%5c..%5csecret.txt",
+		"/%00/../secret.txt",
+		"//../secret.txt",
+	}
+	for _, payload := range payloads {
+		resp, err := noRedirects.Get(server.URL + payload)
+		if err != nil {
+			t.Errorf("GET %s: %v", payload, err)
+			continue
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusMovedPermanently, http.StatusBadRequest, http.StatusNotFound:
+		default:
+			t.Errorf("GET %s without redirects = %d, want a redirect, 400 or 404", payload, resp.StatusCode)
+		}
+		status, body := fetch(t, server, payload)
+		if status == http.StatusOK || strings.Contains(body, "top secret") {
+			t.Errorf("GET %s = %d %q, served a file outside the web pages", payload, status, body)
+		}
+	}
+}
+
+func TestWebFilename(t *testing.T) {
+	tests := []struct {
+		path string
+		name string
+		ok   bool
+	}{
+		{"/", "index.html", true},
+		{"/css/site.css", "css/site.css", true},
+		{"/css//site.css", "css/site.css", true},
+		{"/../secret.txt", "", false},
+		{"/css/../../secret.txt", "", false},
+		{"/..\\secret.txt", "", false},
+		{"/a\x00b", "", false},
+	}
+	for _, test := range tests {
+		name, ok := webFilename(test.path)
+		if ok != test.ok || (ok && name != test.name) {
+			t.Errorf("webFilename(%q) = %q, %t; want %q, %t", test.path, name, ok, test.name, test.ok)
+		}
+	}
+}
+
+// postSearch returns the status and body of a search form POST.
+func postSearch(t *testing.T, server *httptest.Server, pre, post string) (int, string) {
+	t.Helper()
+	resp, err := http.PostForm(server.URL+"/search", url.Values{"pre": {pre}, "post": {post}})
+	if err != nil {
+		t.Fatalf("POST search %q %q: %v", pre, post, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("POST search %q %q: %v", pre, post, err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// resultRow returns the HTML table row of a search result.
+func resultRow(rank, strength int, pre, post string) string {
+	return fmt.Sprintf("<tr><td>%d</td><td>%d</td>", rank, strength) +
+		`<td>` + `[^<]*` + `</td><td>` + regexp.QuoteMeta(template.HTMLEscapeString(pre)) +
+		`</td><td>` + regexp.QuoteMeta(template.HTMLEscapeString(post)) + `</td></tr>`
+}
+
+func TestSearchHandler(t *testing.T) {
+	server := newTestServer(t)
+	tests := []struct {
+		name      string
+		pre, post string
+		rows      []string
+		contains  []string
+	}{
+		{"exact", "L1 209", "Mi1 215",
+			[]string{resultRow(1, 9, "L1 209", "Mi1 215")}, nil},
+		{"strength order", "*", "Mi1 215",
+			[]string{
+				resultRow(1, 9, "L1 209", "Mi1 215"),
+				resultRow(2, 3, "L2 212", "Mi1 215"),
+				resultRow(3, 2, "<script>alert(1)</script>", "Mi1 215"),
+			}, []string{"Total: 14 synapses in 3 connections"}},
+		{"prefix", "L*", "Mi1*",
+			[]string{resultRow(1, 9, "L1 209", "Mi1 215"), resultRow(2, 3, "L2 212", "Mi1 215")}, nil},
+		{"suffix", "L1 209", "*209",
+			[]string{resultRow(1, 5, "L1 209", "Tm1 209")}, nil},
+		{"infix", "*i1*", "*m1*",
+			[]string{resultRow(1, 1, "Mi1 215", "Tm1 209")}, nil},
+		{"list", "L1 209, Mi1 215", "Tm1 209",
+			[]string{resultRow(1, 5, "L1 209", "Tm1 209"), resultRow(2, 1, "Mi1 215", "Tm1 209")}, nil},
+		{"no connections", "Tm1 209", "L1 209", nil,
+			[]string{"No connections found.", "but there are no connections between them"}},
+		{"no matching cells", "Xyz*", "Mi1 215", nil,
+			[]string{"No connections found.", "No cell names match the presynaptic patterns: Xyz*."}},
+		{"escaped pattern", "<b>bold</b>", "*", nil,
+			[]string{"&lt;b&gt;bold&lt;/b&gt;"}},
+	}
+	for _, test := range tests {
+		status, body := postSearch(t, server, test.pre, test.post)
+		if status != http.StatusOK {
+			t.Errorf("%s: status %d", test.name, status)
+			continue
+		}
+		if strings.Contains(body, "<script>") || strings.Contains(body, "<b>") {
+			t.Errorf("%s: unescaped markup in %s", test.name, body)
+		}
+		rows := regexp.MustCompile(`<tr><td>\d+</td>.*?</tr>`).FindAllString(body, -1)
+		if len(rows) != len(test.rows) {
+			t.Errorf("%s: got %d rows %q, want %d", test.name, len(rows), rows, len(test.rows))
+			continue
+		}
+		for i, row := range rows {
+			if !regexp.MustCompile("^" + test.rows[i] + "$").MatchString(row) {
+				t.Errorf("%s: row %d is %q, want %s", test.name, i+1, row, test.rows[i])
+			}
+		}
+		for _, text := range test.contains {
+			if !strings.Contains(body, text) {
+				t.Errorf("%s: page does not contain %q", test.name, text)
+			}
+		}
+	}
+}
+
+func TestReadCellsCSVDuplicates(t *testing.T) {
+	filename := writeFile(t, "cells.csv", "L1 209\nMi1 215\nL1 209\nTm3 92\nMi1 215\nL1 209\n")
+	_, err := ReadCellsCSV(filename, CSVOptions{})
+	if err == nil {
+		t.Fatal("expected an error for repeated names")
+	}
+	for _, want := range []string{`"L1 209" on lines 1, 3, 6`, `"Mi1 215" on lines 2, 5`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "Tm3 92") {
+		t.Errorf("error %q names a distinct cell", err)
+	}
+}
+
+func TestNormalizeNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		collapse bool
+		want     string
+	}{
+		{"Mi1 ", false, "Mi1"},
+		{"Mi4\u00a0", false, "Mi4"},
+		{" Mi1 215\t", false, "Mi1 215"},
+		{"\u00a0Mi1 215\u00a0", false, "Mi1 215"},
+		{"Mi1  215", false, "Mi1  215"},
+		{"Mi1  215 ", true, "Mi1 215"},
+		{"Mi1\u00a0\u00a0215", true, "Mi1 215"},
+	}
+	for _, test := range tests {
+		if got := (CSVOptions{CollapseSpaces: test.collapse}).normalizeName(test.name); got != test.want {
+			t.Errorf("normalizeName(%q) with collapse %t = %q, want %q", test.name, test.collapse, got, test.want)
+		}
+	}
+
+	cells, err := ReadCellsCSV(writeFile(t, "cells.csv", "Mi1 \nTm3 92\u00a0\n"), CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (CellList{"Mi1", "Tm3 92"}); !reflect.DeepEqual(cells, want) {
+		t.Fatalf("cells = %q, want %q", cells, want)
+	}
+	if matches := MatchingNames(cells.NameSet(), []string{"Mi1"}); !reflect.DeepEqual(matches, []string{"Mi1"}) {
+		t.Errorf("exact search for Mi1 matched %q", matches)
+	}
+	connects, err := ReadEdgeListCSV(writeFile(t, "edges.csv", "Mi1 ,Tm3 92,4\n"), CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connects.Strength("Mi1", "Tm3 92") != 4 {
+		t.Errorf("edge list names not normalized: %v", connects.AllConnections())
+	}
+}
+
+func TestMatchNamesExclusions(t *testing.T) {
+	index := NewNameIndex(testNames)
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{"exclude prefix", []string{"Mi*", "-Mi1*"}, []string{}},
+		{"exclude exact", []string{"Mi*", "-Mi1 215"}, []string{"Mi10 3687"}},
+		{"bang exclusion", []string{"*209", "!L1*"}, []string{"Tm1 209"}},
+		{"exclusion first", []string{"-*209", "L*"}, []string{"L2 212", "LC10"}},
+		{"exclusions only", []string{"-Mi1*", "!L1 209"}, []string{}},
+		{"exclude all", []string{"*", "-*"}, []string{}},
+		{"excluded suffix", []string{"*", "-*10", "!*3687"}, []string{"L1 209", "L2 212", "Mi1 215", "Tm1 209"}},
+		{"unmatched exclusion", []string{"L2 212", "-Tm*"}, []string{"L2 212"}},
+		{"blank exclusion", []string{"L2 212", "-"}, []string{"L2 212"}},
+	}
+	for _, test := range tests {
+		for _, indexed := range []*NameIndex{nil, index} {
+			got := sortedNames(matchNames(testNames, indexed, test.patterns, false))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s (indexed %t): %q matched %q, want %q",
+					test.name, indexed != nil, test.patterns, got, test.want)
+			}
+		}
+	}
+}
+
+func TestMatchNamesOverlapping(t *testing.T) {
+	index := NewNameIndex(testNames)
+	tests := []struct {
+		patterns []string
+		want     []string
+	}{
+		{[]string{"Mi1 215", "Mi1*"}, []string{"Mi1 215", "Mi10 3687"}},
+		{[]string{"*209", "L1 209", "L1*"}, []string{"L1 209", "Tm1 209"}},
+		{[]string{"L2 212", "L2 212", "*212"}, []string{"L2 212"}},
+	}
+	for _, test := range tests {
+		for _, indexed := range []*NameIndex{nil, index} {
+			matches := matchNames(testNames, indexed, test.patterns, false)
+			if got := sortedNames(matches); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%q (indexed %t): matched %q, want %q", test.patterns, indexed != nil, got, test.want)
+			}
+		}
+	}
+	// Repeats keep the position of their first match.
+	if matches := matchNames(testNames, index, []string{"Mi10 3687", "Mi1*"}, false); !reflect.DeepEqual(matches, []string{"Mi10 3687", "Mi1 215"}) {
+		t.Errorf("overlapping patterns matched %q in the wrong order", matches)
+	}
+}
+
+// Each connection appears once however many patterns match its cells.
+func TestSearchConnectionsOverlapping(t *testing.T) {
+	nc := NewNamedConnectome()
+	nc.AddConnection("Mi1 215", "Tm1 209", 4)
+	nc.AddConnection("Mi10 3687", "Tm1 209", 2)
+	nc.AddConnection("Mi1 215", "Mi10 3687", 1)
+	d := NewDataset(CellList{"Mi1 215", "Mi10 3687", "Tm1 209"}, nc)
+	connections, err := d.SearchConnections("Mi*,Mi1 215,Mi1*", "*,Tm1 209", SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]int)
+	for _, c := range connections {
+		seen[c.Pre()+" -> "+c.Post()]++
+	}
+	want := map[string]int{"Mi1 215 -> Tm1 209": 1, "Mi10 3687 -> Tm1 209": 1, "Mi1 215 -> Mi10 3687": 1}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("connections %v, want each of %v once", seen, want)
+	}
+}
+
+func TestEndpointMethods(t *testing.T) {
+	server := newTestServer(t)
+	tests := []struct {
+		method, path string
+		status       int
+		allow        string
+	}{
+		{http.MethodHead, "/search?pre=L1*&post=Mi1*", http.StatusOK, ""},
+		{http.MethodGet, "/search?pre=L1*&post=Mi1*", http.StatusOK, ""},
+		{http.MethodPut, "/search", http.StatusMethodNotAllowed, "GET, POST, HEAD"},
+		{http.MethodHead, "/api/search?pre=L1*&post=Mi1*", http.StatusOK, ""},
+		{http.MethodGet, "/admin/reload", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodGet, "/api/batch", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodDelete, "/api/top", http.StatusMethodNotAllowed, "GET, HEAD"},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, server.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", test.method, test.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status || resp.Header.Get("Allow") != test.allow {
+			t.Errorf("%s %s = %d with Allow %q, want %d with Allow %q", test.method, test.path,
+				resp.StatusCode, resp.Header.Get("Allow"), test.status, test.allow)
+		}
+	}
+}
+
+func TestAggregateRegexpLength(t *testing.T) {
+	server := newTestServer(t)
+	long := "(" + strings.Repeat("x", MaxRegexpLength) + ")"
+	for _, test := range []struct {
+		expr   string
+		status int
+	}{
+		{`^(\w+)`, http.StatusOK},
+		{"(", http.StatusBadRequest},
+		{long, http.StatusBadRequest},
+	} {
+		status, body := fetch(t, server, "/api/aggregate?regex="+url.QueryEscape(test.expr))
+		if status != test.status {
+			t.Errorf("aggregate regex %.20q: status %d %s, want %d", test.expr, status, body, test.status)
+		}
+	}
+	if _, body := fetch(t, server, "/api/aggregate?regex="+url.QueryEscape(long)); !strings.Contains(body, "longer than 256 characters") {
+		t.Errorf("aggregate of a long regex: %s", body)
+	}
+}
+
+func TestMatchingRegexpList(t *testing.T) {
+	index := NewNameIndex(testNames)
+	tests := []struct {
+		exprs string
+		want  []string
+	}{
+		{"^L1 ", []string{"L1 209"}},
+		{"L1.*\nMi1 2", []string{"L1 209", "Mi1 215"}},
+		{"209$\r\n ^Mi", []string{"L1 209", "Mi1 215", "Mi10 3687", "Tm1 209"}},
+		{"^L[12]{1,2} ", []string{"L1 209", "L2 212"}},
+		{"^L[C1]{1,2}[0 ]; ^Mi", nil},
+		{"^(L1|LC), 2", nil},
+		{" \n ", nil},
+	}
+	for _, test := range tests {
+		got, err := index.MatchingRegexp(test.exprs, false)
+		if err != nil {
+			t.Errorf("MatchingRegexp(%q): %v", test.exprs, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("MatchingRegexp(%q) = %q, want %q", test.exprs, got, test.want)
+		}
+	}
+	if _, err := index.MatchingRegexp("L1.*\nMi1(", false); err == nil || !strings.Contains(err.Error(), `"Mi1("`) {
+		t.Errorf("invalid second expression: error %v, want one naming it", err)
+	}
+}
+
+func TestSplitPatterns(t *testing.T) {
+	tests := []struct {
+		patterns string
+		want     []string
+	}{
+		{"L1*", []string{"L1*"}},
+		{"L1*,Mi1*", []string{"L1*", "Mi1*"}},
+		{"L1*;Mi1*", []string{"L1*", "Mi1*"}},
+		{"L1*\nMi1*\n", []string{"L1*", "Mi1*"}},
+		{"L1*\r\nMi1*\r\n", []string{"L1*", "Mi1*"}},
+		{"L1*,\nMi1*;;  Tm3*\r\n", []string{"L1*", "Mi1*", "Tm3*"}},
+		{" L1 209 ,, ;\n Mi1 215 ", []string{"L1 209", "Mi1 215"}},
+		{"", nil},
+		{",;\r\n ,", nil},
+	}
+	for _, test := range tests {
+		if got := splitPatterns(test.patterns); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitPatterns(%q) = %q, want %q", test.patterns, got, test.want)
+		}
+	}
+}
+
+func TestSearchMixedSeparators(t *testing.T) {
+	server := newTestServer(t)
+	status, body := postSearch(t, server, "L1*,\nMi1*;;  L2*\r\n", "Mi1 215;\r\nTm1 209,")
+	if status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	rows := regexp.MustCompile(`<tr><td>\d+</td>.*?</tr>`).FindAllString(body, -1)
+	want := []string{
+		resultRow(1, 9, "L1 209", "Mi1 215"),
+		resultRow(2, 5, "L1 209", "Tm1 209"),
+		resultRow(3, 3, "L2 212", "Mi1 215"),
+		resultRow(4, 1, "Mi1 215", "Tm1 209"),
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got rows %q, want %d", rows, len(want))
+	}
+	for i, row := range rows {
+		if !regexp.MustCompile("^" + want[i] + "$").MatchString(row) {
+			t.Errorf("row %d is %q, want %s", i+1, row, want[i])
+		}
+	}
+}
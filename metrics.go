@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bounds, in seconds, of the latency histogram buckets.
+var LatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// histogram counts observations into LatencyBuckets.
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(LatencyBuckets))}
+}
+
+func (h *histogram) observe(seconds float64) {
+	h.count++
+	h.sum += seconds
+	for i, bound := range LatencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			return
+		}
+	}
+}
+
+// write appends the histogram in Prometheus text format, with the given
+// labels, e.g., `path="/api/search"`, which may be empty.
+func (h *histogram) write(b *strings.Builder, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative uint64
+	for i, bound := range LatencyBuckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(b, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep,
+			strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(b, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(b, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(b, "%s_count%s %d\n", name, labels, h.count)
+}
+
+type requestKey struct {
+	path   string
+	status int
+}
+
+// Metrics collects request counts and latencies in memory.
+type Metrics struct {
+	mu           sync.Mutex
+	requests     map[requestKey]uint64
+	latencies    map[string]*histogram
+	searchRender *histogram
+	cacheHits    uint64
+	cacheMisses  uint64
+}
+
+// NewMetrics returns an empty collector.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:     make(map[requestKey]uint64),
+		latencies:    make(map[string]*histogram),
+		searchRender: newHistogram(),
+	}
+}
+
+// observeRequest records a request to the given route pattern.
+func (m *Metrics) observeRequest(path string, status int, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{path, status}]++
+	h, found := m.latencies[path]
+	if !found {
+		h = newHistogram()
+		m.latencies[path] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+// observeSearchRender records the time taken to build a search results page.
+func (m *Metrics) observeSearchRender(elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.searchRender.observe(elapsed.Seconds())
+}
+
+// observeSearchCache records whether a search was answered from the cache.
+func (m *Metrics) observeSearchCache(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+// String returns the metrics in Prometheus text exposition format.
+func (m *Metrics) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+	b.WriteString("# HELP http_requests_total Requests handled, by route and status.\n")
+	b.WriteString("# TYPE http_requests_total counter\n")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].path != keys[j].path {
+			return keys[i].path < keys[j].path
+		}
+		return keys[i].status < keys[j].status
+	})
+	for _, key := range keys {
+		fmt.Fprintf(&b, "http_requests_total{path=%q,code=\"%d\"} %d\n", key.path, key.status, m.requests[key])
+	}
+
+	b.WriteString("# HELP http_request_duration_seconds Request latency, by route.\n")
+	b.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	paths := make([]string, 0, len(m.latencies))
+	for path := range m.latencies {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		m.latencies[path].write(&b, "http_request_duration_seconds", fmt.Sprintf("path=%q", path))
+	}
+
+	b.WriteString("# HELP search_render_duration_seconds Time to build an HTML search results page.\n")
+	b.WriteString("# TYPE search_render_duration_seconds histogram\n")
+	m.searchRender.write(&b, "search_render_duration_seconds", "")
+
+	b.WriteString("# HELP search_cache_hits_total Searches answered from the search cache.\n")
+	b.WriteString("# TYPE search_cache_hits_total counter\n")
+	fmt.Fprintf(&b, "search_cache_hits_total %d\n", m.cacheHits)
+	b.WriteString("# HELP search_cache_misses_total Searches not found in the search cache.\n")
+	b.WriteString("# TYPE search_cache_misses_total counter\n")
+	fmt.Fprintf(&b, "search_cache_misses_total %d\n", m.cacheMisses)
+	return b.String()
+}
+
+// recordMetrics wraps a handler to count and time every request under the
+// route pattern that handles it, which keeps the number of distinct paths
+// bounded no matter what URLs clients request.
+func (s *Server) recordMetrics(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		pattern := s.routePattern(r)
+		rec := &statusRecorder{w, http.StatusOK}
+		handler.ServeHTTP(rec, r)
+		s.metrics.observeRequest(pattern, rec.status, time.Since(start))
+	})
+}
+
+// Handler for the collected metrics in Prometheus text format.
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, s.metrics.String())
+}
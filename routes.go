@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Param is a request parameter accepted by an endpoint.
+type Param struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required,omitempty"`
+}
+
+// required returns the named parameters, marked as required.
+func required(names ...string) []Param {
+	params := make([]Param, len(names))
+	for i, name := range names {
+		params[i] = Param{name, true}
+	}
+	return params
+}
+
+// optional returns the named optional parameters.
+func optional(names ...string) []Param {
+	params := make([]Param, len(names))
+	for i, name := range names {
+		params[i] = Param{Name: name}
+	}
+	return params
+}
+
+// params joins lists of parameters.
+func params(lists ...[]Param) []Param {
+	var all []Param
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	return all
+}
+
+// The optional parameters read by searchOptions.
+var searchParams = optional("ci", "exclude_self", "min_strength", "max_strength",
+	"normalize", "offset", "limit", "regex", "fuzzy", "sort")
+
+// Endpoint is a route served by the Server, described for the /api listing.
+type Endpoint struct {
+	Path        string   `json:"path"`
+	Methods     []string `json:"methods"`
+	Params      []Param  `json:"params,omitempty"`
+	Description string   `json:"description"`
+
+	handler http.HandlerFunc
+}
+
+var (
+	get        = []string{http.MethodGet}
+	post       = []string{http.MethodPost}
+	getAndPost = []string{http.MethodGet, http.MethodPost}
+)
+
+// endpoints is the registry of every route except the web pages.  Both
+// routes and the /api listing are built from it so they stay in sync.
+func (s *Server) endpoints() []Endpoint {
+	return []Endpoint{
+		{"/search", getAndPost, params(optional("pre", "post", "type", "format"), searchParams),
+			"HTML search results, or a CSV file with format=csv.", s.searchHandler},
+		{"/admin/reload", post, nil,
+			"Reload the data files.", s.reloadHandler},
+		{"/healthz", get, nil,
+			"Health, version and size of the loaded data.", s.healthzHandler},
+		{"/metrics", get, nil,
+			"Request counts and latencies in Prometheus text format.", s.metricsHandler},
+		{"/api", get, nil,
+			"This list of endpoints.", recoverAPI(s.apiIndexHandler)},
+		{WebAPIPath + "search", getAndPost, params(optional("pre", "post", "type", "matches"), searchParams),
+			"Connections between cells matching the pre and post patterns.", s.apiSearchHandler},
+		{WebAPIPath + "batch", post, params(optional("type"), searchParams),
+			"Results for a JSON array of {pre, post} queries.", s.apiBatchHandler},
+		{WebAPIPath + "incoming", get, required("cell"),
+			"Connections onto a cell, strongest first.", s.apiIncomingHandler},
+		{WebAPIPath + "around", get, params(required("cell"), optional("n")),
+			"Strongest connections from or onto a cell, ranked together.", s.apiAroundHandler},
+		{WebAPIPath + "neighbors", get, params(required("cell"), optional("n", "min_strength")),
+			"Strongest partners of a cell in each direction.", s.apiNeighborsHandler},
+		{WebAPIPath + "neighborhood", get, params(required("cell"), optional("depth", "direction", "min_strength", "max_nodes")),
+			"Cells within some hops of a cell and the connections among them.", s.apiNeighborhoodHandler},
+		{WebAPIPath + "output-profile", get, required("cell"),
+			"Postsynaptic partners of a cell with their share of its output.", s.apiOutputProfileHandler},
+		{WebAPIPath + "common-targets", get, required("cells"),
+			"Postsynaptic cells shared by every listed cell.", s.apiCommonTargetsHandler},
+		{WebAPIPath + "common-sources", get, required("cells"),
+			"Presynaptic cells shared by every listed cell.", s.apiCommonSourcesHandler},
+		{WebAPIPath + "similarity", get, params(required("a", "b"), optional("direction", "metric")),
+			"How alike two cells' connections are.", s.apiSimilarityHandler},
+		{WebAPIPath + "similar", get, params(required("cell"), optional("n", "direction", "metric")),
+			"Cells whose connections most resemble a cell's.", s.apiSimilarHandler},
+		{WebAPIPath + "top", get, optional("n", "min_strength"),
+			"Strongest connections in the connectome.", s.apiTopHandler},
+		{WebAPIPath + "edge", get, required("pre", "post"),
+			"Whether one cell connects to another, and how strongly.", s.apiEdgeHandler},
+		{WebAPIPath + "degree", get, required("cell"),
+			"Synapses and partners of a cell in each direction.", s.apiDegreeHandler},
+		{WebAPIPath + "degree-histogram", get, optional("by"),
+			"Number of cells with each degree.", s.apiDegreeHistogramHandler},
+		{WebAPIPath + "centrality", get, optional("metric", "direction", "top"),
+			"Cells ranked by degree.", s.apiCentralityHandler},
+		{WebAPIPath + "path", get, params(required("from", "to"), optional("maxhops")),
+			"A path with the fewest connections between two cells.", s.apiPathHandler},
+		{WebAPIPath + "strongpath", get, params(required("from", "to"), optional("metric")),
+			"The strongest path between two cells.", s.apiStrongPathHandler},
+		{WebAPIPath + "walk", get, params(required("start"), optional("steps", "seed")),
+			"A random walk weighted by connection strength.", s.apiWalkHandler},
+		{WebAPIPath + "aggregate", get, optional("delim", "regex"),
+			"Summed strengths between cell types.", s.apiAggregateHandler},
+		{WebAPIPath + "undirected", get, optional("mode"),
+			"The connectome with both directions combined.", s.apiUndirectedHandler},
+		{WebAPIPath + "reciprocal", get, optional("min_strength"),
+			"Pairs of cells connected in both directions.", s.apiReciprocalHandler},
+		{WebAPIPath + "scc", get, optional("min_strength", "singletons"),
+			"Strongly connected components, largest first.", s.apiSCCHandler},
+		{WebAPIPath + "autapses", get, nil,
+			"Self-connections, strongest first.", s.apiAutapsesHandler},
+		{WebAPIPath + "names", get, optional("prefix", "ci"),
+			"Cell names starting with a prefix.", s.apiNamesHandler},
+		{WebAPIPath + "stats", get, nil,
+			"Summary statistics of the connectome.", s.apiStatsHandler},
+		{WebAPIPath + "isolated", get, nil,
+			"Cells lacking input, output or both.", s.apiIsolatedHandler},
+		{WebAPIPath + "diff", get, params(required("from", "to"), searchParams),
+			"Changed connections against the -connect2 connectome.", s.apiDiffHandler},
+		{"/export/graphml", get, optional("min_strength", "prune"),
+			"The connectome as GraphML.", s.exportGraphMLHandler},
+		{"/export/dot", get, params(required("cell"), optional("depth", "min_strength", "prune")),
+			"A cell's neighborhood as a Graphviz digraph.", s.exportDOTHandler},
+		{"/export/cytoscape", get, params(required("cell"), optional("depth", "min_strength", "prune")),
+			"A cell's neighborhood as Cytoscape.js elements.", s.exportCytoscapeHandler},
+		{"/export/ndjson", get, optional("prune"),
+			"Every connection as newline-delimited JSON.", s.exportNDJSONHandler},
+		{"/export/cells.csv", get, optional("sort", "prune"),
+			"Each cell's synapse totals and partner counts as CSV.", s.exportCellsHandler},
+		{"/export/submatrix", getAndPost, params(required("cells"), optional("prune")),
+			"The connectivity matrix among the listed cells as CSV.", s.exportSubmatrixHandler},
+	}
+}
+
+// routes registers every endpoint.  Those under WebAPIPath go on a separate
+// API router, mounted at that prefix so that all of them recover from
+// panics and unknown API paths get a JSON error rather than a web page.
+// All other paths are web pages.
+func (s *Server) routes() {
+	for _, endpoint := range s.endpoints() {
+		if strings.HasPrefix(endpoint.Path, WebAPIPath) {
+			s.api.HandleFunc(endpoint.Path, endpoint.allowMethods())
+		} else {
+			s.mux.HandleFunc(endpoint.Path, endpoint.allowMethods())
+		}
+	}
+	s.api.HandleFunc(WebAPIPath, s.apiIndexHandler)
+	s.mux.HandleFunc(WebAPIPath, recoverAPI(s.api.ServeHTTP))
+	s.mux.HandleFunc("/", s.mainHandler)
+}
+
+// allowMethods returns the endpoint's handler wrapped to answer any method
+// it doesn't list with a 405 and an Allow header, as a JSON error under
+// WebAPIPath.  HEAD is allowed wherever GET is, and listed as allowed too.
+func (endpoint Endpoint) allowMethods() http.HandlerFunc {
+	methods := endpoint.Methods
+	if slices.Contains(methods, http.MethodGet) && !slices.Contains(methods, http.MethodHead) {
+		methods = append(slices.Clip(methods), http.MethodHead)
+	}
+	allowed := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		for _, m := range endpoint.Methods {
+			if m == method {
+				endpoint.handler(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", allowed)
+		msg := fmt.Sprintf("Method %s not allowed.  Use %s.", r.Method, allowed)
+		if strings.HasPrefix(endpoint.Path, WebAPIPath) {
+			apiError(w, msg, http.StatusMethodNotAllowed)
+		} else {
+			http.Error(w, msg, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Handler listing the endpoints, their parameters and methods, at /api.
+// Any other path under /api/ that matches no endpoint is a JSON 404.
+func (s *Server) apiIndexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api" && r.URL.Path != WebAPIPath {
+		apiError(w, "Unknown API endpoint: "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, s.endpoints())
+}
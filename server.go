@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ConnectomeFile is a connectivity file loaded as the connectome of the
+// given type, such as "chemical" or "electrical".
+type ConnectomeFile struct {
+	Name, Filename string
+}
+
+// Config holds the settings of a Server, normally taken from the
+// command-line flags.
+type Config struct {
+	// CSV files to load and how to parse them.  See LoadDataset.  Each
+	// connectome uses the same cells, and the first is served unless a
+	// request picks another by type.  The optional second connectivity
+	// file is compared against the first by /api/diff.
+	CellsFilename         string
+	Connectomes           []ConnectomeFile
+	ConnectivityFilename2 string
+	Format                string
+	IDMapFilename         string
+	CSV                   CSVOptions
+
+	// Default for the "ci" search parameter.
+	IgnoreCase bool
+
+	// Most connections shown on one HTML results page or returned by one
+	// /api/batch request, or 0 for no limit.
+	MaxResults int
+
+	// Web pages served for paths outside the API.
+	WebPages fs.FS
+
+	// Comma-separated origins allowed to make cross-origin API requests.
+	AllowOrigin string
+
+	// Never gzip responses.
+	NoCompress bool
+
+	// Number of searches whose results are cached, or 0 for none.
+	SearchCacheSize int
+
+	// Largest request body read when parsing search forms, or 0 for no
+	// limit.
+	MaxBodyBytes int64
+
+	// Token a reload request must present, or empty to allow reloads only
+	// from loopback addresses.
+	ReloadToken string
+}
+
+// Server serves the web pages, API and exports for a loaded Dataset.  The
+// data is guarded by a lock since a reload can swap it out while searches
+// are in flight.
+type Server struct {
+	config  Config
+	metrics *Metrics
+	mux     *http.ServeMux
+	api     *http.ServeMux
+	handler http.Handler
+
+	mu    sync.RWMutex
+	data  *Dataset
+	data2 *Dataset
+	types map[string]*Dataset
+}
+
+// NewServer returns a Server with all routes registered.  It has no data
+// until Load or SetData is called.
+func NewServer(config Config) *Server {
+	s := &Server{
+		config:  config,
+		metrics: NewMetrics(),
+		mux:     http.NewServeMux(),
+		api:     http.NewServeMux(),
+	}
+	s.routes()
+	var handler http.Handler = s.mux
+	if !config.NoCompress {
+		handler = compressResponses(handler)
+	}
+	if config.AllowOrigin != "" {
+		handler = allowCORS(config.AllowOrigin, handler)
+	}
+	s.handler = logRequests(s.recordMetrics(handler))
+	return s
+}
+
+// routePattern returns the pattern of the route that handles a request,
+// looking inside the API router for requests under WebAPIPath.
+func (s *Server) routePattern(r *http.Request) string {
+	_, pattern := s.mux.Handler(r)
+	if pattern == WebAPIPath {
+		_, pattern = s.api.Handler(r)
+	}
+	return pattern
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// SetData swaps in the datasets to serve, the first being the default, and
+// the optional second dataset to compare the first to.
+func (s *Server) SetData(datasets []*Dataset, d2 *Dataset) {
+	types := make(map[string]*Dataset, len(datasets))
+	for _, d := range datasets {
+		types[d.Type] = d
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data, s.data2, s.types = datasets[0], d2, types
+}
+
+// currentData returns the dataset being served.
+func (s *Server) currentData() *Dataset {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data
+}
+
+// limitBody limits the request's body to MaxBodyBytes, if set, so reading
+// more fails with an *http.MaxBytesError.
+func (s *Server) limitBody(w http.ResponseWriter, r *http.Request) {
+	if s.config.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxBodyBytes)
+	}
+}
+
+// bodyTooLarge returns a 413 error message if err came from reading past
+// the limitBody limit.
+func bodyTooLarge(err error) (string, bool) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Sprintf("request body larger than %d bytes", tooLarge.Limit), true
+	}
+	return "", false
+}
+
+// parseForm parses the request's form after limiting its body to
+// MaxBodyBytes.  On failure it also returns the status to report: 413 if
+// the body was too large and 400 otherwise.
+func (s *Server) parseForm(w http.ResponseWriter, r *http.Request) (int, error) {
+	s.limitBody(w, r)
+	err := r.ParseForm()
+	if msg, found := bodyTooLarge(err); found {
+		return http.StatusRequestEntityTooLarge, errors.New(msg)
+	} else if err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, nil
+}
+
+// requestData returns the dataset of the connectome type named by the
+// request's "type" parameter, or the default dataset if there is none.
+func (s *Server) requestData(r *http.Request) (*Dataset, error) {
+	name := r.FormValue("type")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if name == "" {
+		return s.data, nil
+	}
+	if d, found := s.types[name]; found {
+		return d, nil
+	}
+	names := make([]string, len(s.config.Connectomes))
+	for i, connectome := range s.config.Connectomes {
+		names[i] = connectome.Name
+	}
+	return nil, fmt.Errorf("unknown connectome type %q, use one of: %s", name, strings.Join(names, ", "))
+}
+
+// comparisonData returns the dataset being served and the second dataset
+// to compare it to, which is nil unless a second connectome was loaded.
+func (s *Server) comparisonData() (*Dataset, *Dataset) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data, s.data2
+}
+
+// Load reads the configured CSV files and, only if all load without
+// error, swaps them in for the currently served data.  At least one
+// connectome must be configured.
+func (s *Server) Load() error {
+	config := s.config
+	if len(config.Connectomes) == 0 {
+		return errors.New("no connectivity files configured")
+	}
+	var bodyNames map[string]string
+	if config.IDMapFilename != "" {
+		var err error
+		if bodyNames, err = ReadIDMapCSV(config.IDMapFilename, config.CSV); err != nil {
+			return err
+		}
+	}
+	datasets := make([]*Dataset, len(config.Connectomes))
+	for i, connectome := range config.Connectomes {
+		d, err := LoadDataset(config.CellsFilename, connectome.Filename, config.Format, config.CSV)
+		if err != nil {
+			return err
+		}
+		d.Type = connectome.Name
+		d.BodyNames = bodyNames
+		d.searches = NewSearchCache(config.SearchCacheSize)
+		datasets[i] = d
+	}
+	d := datasets[0]
+	var d2 *Dataset
+	if config.ConnectivityFilename2 != "" {
+		var err error
+		d2, err = LoadDataset(config.CellsFilename, config.ConnectivityFilename2, config.Format, config.CSV)
+		if err != nil {
+			return err
+		}
+		d2.BodyNames = d.BodyNames
+		log.Printf("Loaded %d connections to compare from %s.\n",
+			d2.Connectivity.NumConnections(), config.ConnectivityFilename2)
+	}
+	s.SetData(datasets, d2)
+	log.Printf("Loaded %d cells and %d connections with %d synapses (density %.4f).\n",
+		len(d.Cells), d.Stats.Connections, d.Stats.Synapses, d.Stats.Density)
+	for _, other := range datasets[1:] {
+		log.Printf("Loaded %d connections with %d synapses (density %.4f) of type %q.\n",
+			other.Stats.Connections, other.Stats.Synapses, other.Stats.Density, other.Type)
+	}
+	if isolated := d.Isolated().Isolated; len(isolated) > 0 {
+		slog.Warn("cells have no connections at all", "count", len(isolated), "cells", isolated)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Searches racing a reload must each see one whole dataset, old or new.
+// Run with -race to check the data swap is properly locked.
+func TestSearchDuringReload(t *testing.T) {
+	cellsFile := writeFile(t, "cells.csv", "A 1\nB 2\nC 3\n")
+	matrixFile := writeFile(t, "matrix.csv", "0,5,1\n2,0,7\n3,0,0\n")
+	s := NewServer(Config{
+		CellsFilename:   cellsFile,
+		Connectomes:     []ConnectomeFile{{connectomeName(matrixFile), matrixFile}},
+		Format:          FormatMatrix,
+		SearchCacheSize: DefaultSearchCacheSize,
+	})
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	const apiPath = "/api/search?pre=A*&post=*"
+	search := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+	transpose := func() {
+		d := s.currentData()
+		s.SetData([]*Dataset{NewDataset(d.Cells, d.Connectivity.Transpose())}, nil)
+	}
+	// The API results of the loaded and the transposed data.
+	_, original := search(apiPath)
+	transpose()
+	_, transposed := search(apiPath)
+	if original == transposed {
+		t.Fatalf("transposing did not change the results %s", original)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := s.Load(); err != nil {
+				t.Error(err)
+			}
+			transpose()
+		}
+		close(done)
+	}()
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if status, _ := search("/search?pre=*&post=*"); status != http.StatusOK {
+					t.Errorf("HTML search during reload: status %d", status)
+					return
+				}
+				status, body := search(apiPath)
+				if status != http.StatusOK {
+					t.Errorf("API search during reload: status %d", status)
+					return
+				}
+				if body != original && body != transposed {
+					t.Errorf("API search during reload got %s, want %s or %s", body, original, transposed)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestReloadAllowed(t *testing.T) {
+	cellsFile := writeFile(t, "cells.csv", "A 1\nB 2\n")
+	matrixFile := writeFile(t, "matrix.csv", "0,5\n2,0\n")
+	config := Config{
+		CellsFilename: cellsFile,
+		Connectomes:   []ConnectomeFile{{connectomeName(matrixFile), matrixFile}},
+		Format:        FormatMatrix,
+	}
+	tests := []struct {
+		name          string
+		token         string
+		remoteAddr    string
+		authorization string
+		forwardedFor  string
+		status        int
+	}{
+		{"loopback", "", "127.0.0.1:4321", "", "", http.StatusOK},
+		{"loopback v6", "", "[::1]:4321", "", "", http.StatusOK},
+		{"remote", "", "192.0.2.1:4321", "", "", http.StatusForbidden},
+		{"remote with token", "secret", "192.0.2.1:4321", "Bearer secret", "", http.StatusOK},
+		{"wrong token", "secret", "192.0.2.1:4321", "Bearer guess", "", http.StatusForbidden},
+		{"loopback without token", "secret", "127.0.0.1:4321", "", "", http.StatusForbidden},
+		{"proxied", "", "127.0.0.1:4321", "", "198.51.100.7", http.StatusForbidden},
+		{"proxied with token", "secret", "127.0.0.1:4321", "Bearer secret", "198.51.100.7", http.StatusOK},
+	}
+	for _, test := range tests {
+		config.ReloadToken = test.token
+		s := NewServer(config)
+		if err := s.Load(); err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		if test.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, rec.Code, test.status)
+		}
+	}
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	s := NewServer(Config{})
+	s.SetData([]*Dataset{NewDataset(CellList{"A"}, NewNamedConnectome())}, nil)
+	for _, test := range []struct {
+		id    string
+		reuse bool
+	}{
+		{"abc-123_x.y", true},
+		{strings.Repeat("a", MaxRequestIDLength), true},
+		{strings.Repeat("a", MaxRequestIDLength+1), false},
+		{"forged status=500", false},
+		{"id\nlevel=ERROR", false},
+		{"", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		req.Header.Set("X-Request-ID", test.id)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		got := rec.Header().Get("X-Request-ID")
+		if test.reuse && got != test.id {
+			t.Errorf("X-Request-ID %q came back as %q", test.id, got)
+		} else if !test.reuse && (got == test.id || !validRequestID(got)) {
+			t.Errorf("X-Request-ID %q was replaced by %q, want a new id", test.id, got)
+		}
+	}
+}
+
+func TestReloadWithoutFiles(t *testing.T) {
+	s := NewServer(Config{})
+	s.SetData([]*Dataset{NewDataset(CellList{"A"}, NewNamedConnectome())}, nil)
+	req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	req.RemoteAddr = "127.0.0.1:4321"
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "no connectivity files") {
+		t.Errorf("reload without files: status %d %s", rec.Code, rec.Body)
+	}
+	if len(s.currentData().Cells) != 1 {
+		t.Errorf("failed reload replaced the data")
+	}
+}
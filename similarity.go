@@ -0,0 +1,183 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// SimilarityMetric selects how two cells' connectivity is compared.
+type SimilarityMetric int
+
+const (
+	// JaccardSimilarity is the weighted Jaccard index: the sum over
+	// partners of the smaller strength divided by the sum of the larger.
+	JaccardSimilarity SimilarityMetric = iota
+
+	// CosineSimilarity is the cosine of the angle between the two cells'
+	// vectors of partner strengths.
+	CosineSimilarity
+)
+
+// partnerKey is a partner of a cell in one direction, so a cell that is
+// both a target and a source counts as two distinct partners.
+type partnerKey struct {
+	cell string
+	dir  Direction
+}
+
+// partnerVector holds the strength of a cell's connection to each partner.
+type partnerVector map[partnerKey]int
+
+// partnerVector returns the strengths of the cell's connections in the
+// given direction.
+func (nc *NamedConnectome) partnerVector(cell string, dir Direction) partnerVector {
+	vector := make(partnerVector)
+	if dir&Outgoing != 0 {
+		nc.EachOutgoing(cell, func(post string, strength int) {
+			vector[partnerKey{post, Outgoing}] = strength
+		})
+	}
+	if dir&Incoming != 0 {
+		nc.EachIncoming(cell, func(pre string, strength int) {
+			vector[partnerKey{pre, Incoming}] = strength
+		})
+	}
+	return vector
+}
+
+// vectorCache holds the partner vectors of every cell in a connectome,
+// built the first time a direction is asked for, since ranking one cell
+// against all the others would otherwise rebuild them on every request.
+type vectorCache struct {
+	mu    sync.Mutex
+	byDir map[Direction]map[string]partnerVector
+}
+
+// allPartnerVectors returns the partner vector of every cell in the given
+// direction, keyed by cell name.  The result must not be modified.
+func (nc *NamedConnectome) allPartnerVectors(dir Direction) map[string]partnerVector {
+	nc.vectors.mu.Lock()
+	defer nc.vectors.mu.Unlock()
+	if vectors, found := nc.vectors.byDir[dir]; found {
+		return vectors
+	}
+	vectors := make(map[string]partnerVector, len(nc.names))
+	for _, cell := range nc.names {
+		vectors[cell] = nc.partnerVector(cell, dir)
+	}
+	if nc.vectors.byDir == nil {
+		nc.vectors.byDir = make(map[Direction]map[string]partnerVector)
+	}
+	nc.vectors.byDir[dir] = vectors
+	return vectors
+}
+
+// score compares two partner vectors, returning a value from 0 for no
+// shared partners to 1 for identical connectivity.
+func (metric SimilarityMetric) score(a, b partnerVector) float64 {
+	if metric == CosineSimilarity {
+		var dot, normA, normB float64
+		for key, strength := range a {
+			dot += float64(strength) * float64(b[key])
+			normA += float64(strength) * float64(strength)
+		}
+		for _, strength := range b {
+			normB += float64(strength) * float64(strength)
+		}
+		if dot == 0 {
+			return 0
+		}
+		return dot / math.Sqrt(normA*normB)
+	}
+	var minSum, maxSum int
+	for key, strength := range a {
+		minSum += min(strength, b[key])
+		maxSum += max(strength, b[key])
+	}
+	for key, strength := range b {
+		if _, found := a[key]; !found {
+			maxSum += strength
+		}
+	}
+	if minSum == 0 {
+		return 0
+	}
+	return float64(minSum) / float64(maxSum)
+}
+
+// SharedConnection is a partner of two cells in the same direction, with
+// the strength of each cell's connection to it.
+type SharedConnection struct {
+	Cell      string `json:"cell"`
+	Direction string `json:"direction"`
+	A         int    `json:"a"`
+	B         int    `json:"b"`
+}
+
+// Similarity scores how alike the connections of cells a and b are in the
+// given direction, and returns the partners they share, strongest first
+// by combined strength and then by name.
+func (nc *NamedConnectome) Similarity(a, b string, dir Direction, metric SimilarityMetric) (score float64, shared []SharedConnection) {
+	vectorA, vectorB := nc.partnerVector(a, dir), nc.partnerVector(b, dir)
+	shared = make([]SharedConnection, 0)
+	for key, strengthA := range vectorA {
+		if strengthB, found := vectorB[key]; found {
+			shared = append(shared, SharedConnection{key.cell, directionName(key.dir), strengthA, strengthB})
+		}
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		si, sj := shared[i].A+shared[i].B, shared[j].A+shared[j].B
+		if si != sj {
+			return si > sj
+		}
+		if shared[i].Cell != shared[j].Cell {
+			return shared[i].Cell < shared[j].Cell
+		}
+		return shared[i].Direction > shared[j].Direction
+	})
+	return metric.score(vectorA, vectorB), shared
+}
+
+// SimilarCell is a cell and how alike its connections are to another's.
+type SimilarCell struct {
+	Cell   string  `json:"cell"`
+	Score  float64 `json:"score"`
+	Shared int     `json:"shared"`
+}
+
+// MostSimilar scores every other cell against the given cell and returns
+// the n with the most similar connections in the given direction, highest
+// score first and then by name.  Cells sharing no partners are omitted, and
+// n of 0 returns every match.
+func (nc *NamedConnectome) MostSimilar(cell string, dir Direction, metric SimilarityMetric, n int) []SimilarCell {
+	vectors := nc.allPartnerVectors(dir)
+	target := vectors[cell]
+	matches := make([]SimilarCell, 0)
+	for other, vector := range vectors {
+		if other == cell {
+			continue
+		}
+		shared := 0
+		for key := range target {
+			if _, found := vector[key]; found {
+				shared++
+			}
+		}
+		if shared == 0 {
+			continue
+		}
+		score := math.Round(metric.score(target, vector)*1e6) / 1e6
+		matches = append(matches, SimilarCell{other, score, shared})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Cell < matches[j].Cell
+	})
+	if n > 0 && n < len(matches) {
+		matches = matches[:n]
+	}
+	return matches
+}
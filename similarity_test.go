@@ -0,0 +1,19 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCosineSimilarityLargeStrengths(t *testing.T) {
+	const big = 1 << 40
+	nc := NewNamedConnectome()
+	nc.AddConnection("A", "C", big)
+	nc.AddConnection("A", "D", 3*big)
+	nc.AddConnection("B", "C", big)
+	nc.AddConnection("B", "D", 3*big)
+	score, _ := nc.Similarity("A", "B", Outgoing, CosineSimilarity)
+	if math.Abs(score-1) > 1e-9 {
+		t.Errorf("cosine similarity of identical large rows = %g, want 1", score)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Version and BuildDate identify the build.  Release builds set them with
+//
+//	go build -ldflags "-X main.Version=v1.2.0 -X main.BuildDate=2024-05-01"
+var (
+	Version   = "dev"
+	BuildDate = "unknown"
+)
+
+// versionString describes the build for -version and /healthz.
+func versionString() string {
+	return fmt.Sprintf("%s (built %s with %s)", Version, BuildDate, runtime.Version())
+}